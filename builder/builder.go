@@ -9,8 +9,6 @@ import (
 	"regexp"
 	"strings"
 	"time"
-
-	"golang.org/x/mod/modfile"
 )
 
 // Example of use
@@ -33,15 +31,33 @@ func Build() {
 
 // BuildConfig is the configuration for the build process
 type BuildConfig struct {
-	DefaultMode      string   // dev, prod, local
-	OutputFilename   string   // name of the output file
-	OutputDir        string   // path to the output directory. (It will create a "builds" directory inside this path)
-	SourceFile       string   // path to the source file
-	BuildLinux       bool     // true if is necessary build for Linux
-	BuildWindows     bool     // true if is necessary build for Windows
-	PossibleDirs     []string // possible directories to find the config file. For example: ["", "configs", "cfg", "config", "internal/config"]
-	ConfigExtensions []string // possible extensions of the config file. For example: ["toml", "yaml"]
-	AddAppOnConfig   bool     // true if is necessary add the app section on the config file
+	DefaultMode       string   // dev, prod, local
+	OutputFilename    string   // name of the output file
+	OutputDir         string   // path to the output directory. (It will create a "builds" directory inside this path)
+	SourceFile        string   // path to the source file
+	BuildLinux        bool     // true if is necessary build for Linux
+	BuildWindows      bool     // true if is necessary build for Windows
+	PossibleDirs      []string // possible directories to find the config file. For example: ["", "configs", "cfg", "config", "internal/config"]
+	ConfigExtensions  []string // possible extensions of the config file. For example: ["toml", "yaml"]
+	AddAppOnConfig    bool     // true if is necessary add the app section on the config file
+	TemplateConfig    bool     // true if is necessary resolve ${VAR} and {{ .Env.VAR }} placeholders on the config file
+	EnvFiles          []string // .env files with variables for the config template. For example: [".env"]
+	ValuesFile        string   // per-mode values file, "{mode}" is replaced with DefaultMode. For example: "configs/values.{mode}.env"
+	EmbedConfig       bool     // true if is necessary embed the mode-patched config into the binary (see builder/embedded)
+	Sources           []Source // additional binaries to build, possibly from other modules of a go.work workspace
+	VersionVariable   string   // variable receiving the git version through -ldflags -X. For example: "main.Version"
+	GenerateChangelog bool     // true if is necessary write CHANGELOG.md and release-notes.json when building on a git tag
+
+	AnalyzeSize       bool    // true if is necessary write a size report of the binaries and compare it with the previous build
+	SizeBudgetPercent float64 // maximum allowed growth of a binary compared with the previous build, in percent. 0 disables the budget
+	SizeBudgetFail    bool    // true if exceeding the size budget must fail the build instead of logging a warning
+
+	RunTests     bool     // true if is necessary run the tests with JUnit and coverage reports before compiling
+	TestPackages []string // packages to test. Default: ["./..."]
+	MinCoverage  float64  // minimum total coverage in percent. 0 disables the threshold
+	ProdModes    []string // modes whose artifacts are refused when tests fail or coverage is too low. Default: ["prod"]
+
+	Publish *PublishConfig // optional upload of the build directory to S3-compatible storage after a successful build
 }
 
 func finalization() {
@@ -68,6 +84,16 @@ func (config *BuildConfig) validate() error {
 	if len(config.ConfigExtensions) == 0 {
 		return fmt.Errorf("ConfigExtensions is required")
 	}
+	for i, source := range config.Sources {
+		if source.SourceFile == "" || source.OutputFilename == "" {
+			return fmt.Errorf("Sources[%d]: SourceFile and OutputFilename are required", i)
+		}
+	}
+	if config.Publish != nil {
+		if err := config.Publish.validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -87,16 +113,33 @@ func (config *BuildConfig) Run() {
 	}
 	log.Println("Current working directory:", wd)
 
-	// Read file go.mod
-	data, err := os.ReadFile("go.mod")
-	if err != nil {
-		log.Fatalf("Error reading go.mod file: %v", err)
+	// Find the module owning each source file
+	sources := append([]Source{{SourceFile: config.SourceFile, OutputFilename: config.OutputFilename}}, config.Sources...)
+	modules := make([]*goModule, len(sources))
+	for i, source := range sources {
+		module, err := findModule(source.SourceFile)
+		if err != nil {
+			log.Fatalf("Error finding module: %v", err)
+		}
+		modules[i] = module
+		log.Printf("Source %s belongs to module %s (%s)\n", source.SourceFile, module.Path, module.Dir)
 	}
 
-	// Parsing go.mod
-	modFile, err := modfile.Parse("go.mod", data, nil)
+	// Check the go.work workspace of every module
+	workPath, workFile, err := findModulesWorkspace(modules)
 	if err != nil {
-		log.Fatalf("Error parsing go.mod file: %v", err)
+		log.Fatalf("Workspace error: %v", err)
+	}
+	if workFile != nil {
+		log.Println("Using workspace:", workPath)
+	}
+
+	// Detect the version from git
+	version := detectVersion(wd)
+	log.Println("Version:", version)
+	var ldflags string
+	if config.VersionVariable != "" {
+		ldflags = fmt.Sprintf("-X %s=%s", config.VersionVariable, version)
 	}
 
 	// Create output directory
@@ -106,20 +149,9 @@ func (config *BuildConfig) Run() {
 		log.Fatalf("Error creating build directory: %v", err)
 	}
 
-	// Build for Linux
-	if config.BuildLinux {
-		linuxOutput := config.OutputFilename + ".linux"
-		if err := buildForOS("linux", linuxOutput, config.SourceFile, outputDir); err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	// Build for Windows
-	if config.BuildWindows {
-		windowsOutput := modFile.Module.Mod.Path + ".exe"
-		if err := buildForOS("windows", windowsOutput, config.SourceFile, outputDir); err != nil {
-			log.Fatal(err)
-		}
+	// Run the tests before compiling
+	if config.RunTests {
+		config.checkTestGate(outputDir, modules)
 	}
 
 	// Update and copy config file
@@ -127,17 +159,122 @@ func (config *BuildConfig) Run() {
 	if err != nil {
 		log.Fatalf("Error finding config file: %v", err)
 	}
+	var templateVars map[string]string
+	if config.TemplateConfig {
+		templateVars, err = config.loadTemplateVars()
+		if err != nil {
+			log.Fatalf("Error loading config template variables: %v", err)
+		}
+	}
 	destConfigFilePath := filepath.Join(outputDir, "config"+filepath.Ext(configFile))
-	if err := updateAndCopyConfigFile(configFile, destConfigFilePath, config.DefaultMode, config.AddAppOnConfig); err != nil {
+	if err := updateAndCopyConfigFile(configFile, destConfigFilePath, config.DefaultMode, config.AddAppOnConfig, templateVars); err != nil {
 		log.Fatalf("Error updating and copying config file: %v", err)
 	} else {
 		log.Println("Successfully updated and copied config file to:", destConfigFilePath)
 	}
 
+	manifest := &buildManifest{
+		Mode:       config.DefaultMode,
+		Version:    version,
+		CreatedAt:  time.Now(),
+		Workspace:  workPath,
+		ConfigFile: filepath.Base(destConfigFilePath),
+	}
+
+	for i, source := range sources {
+		module := modules[i]
+
+		// Generate the embedded config file
+		var overlay *embedOverlay
+		var overlayPath string
+		var extraFiles []string
+		if config.EmbedConfig {
+			content, err := os.ReadFile(destConfigFilePath)
+			if err != nil {
+				log.Fatalf("Error reading config file to embed: %v", err)
+			}
+			overlay, err = generateEmbedFile(source.SourceFile, filepath.Base(destConfigFilePath), content)
+			if err != nil {
+				log.Fatalf("Error generating embedded config: %v", err)
+			}
+			overlayPath = overlay.overlay
+			if strings.HasSuffix(source.SourceFile, ".go") {
+				extraFiles = append(extraFiles, overlay.file)
+			}
+		}
+
+		// Build for Linux
+		if config.BuildLinux {
+			linuxOutput := source.OutputFilename + ".linux"
+			if err := buildForOS("linux", linuxOutput, source.SourceFile, outputDir, module.Dir, ldflags, overlayPath, extraFiles...); err != nil {
+				overlay.remove()
+				log.Fatal(err)
+			}
+			manifest.Artifacts = append(manifest.Artifacts, config.artifact(outputDir, linuxOutput, "linux", source, module))
+		}
+
+		// Build for Windows
+		if config.BuildWindows {
+			windowsOutput := source.OutputFilename + ".exe"
+			if err := buildForOS("windows", windowsOutput, source.SourceFile, outputDir, module.Dir, ldflags, overlayPath, extraFiles...); err != nil {
+				overlay.remove()
+				log.Fatal(err)
+			}
+			manifest.Artifacts = append(manifest.Artifacts, config.artifact(outputDir, windowsOutput, "windows", source, module))
+		}
+
+		overlay.remove()
+	}
+
+	// Generate changelog and release notes
+	if config.GenerateChangelog {
+		if tag := currentTag(wd); tag == "" {
+			log.Println("HEAD is not tagged, skipping changelog generation")
+		} else if err := generateReleaseNotes(wd, outputDir, tag, version, config.VersionVariable); err != nil {
+			log.Fatalf("Error generating changelog: %v", err)
+		} else {
+			log.Println("Successfully wrote changelog and release notes for", tag)
+		}
+	}
+
+	// Analyze binary sizes
+	if config.AnalyzeSize {
+		config.checkBinarySizes(outputDir, manifest.Artifacts)
+	}
+
+	// Write the manifest
+	if err := writeManifest(outputDir, manifest); err != nil {
+		log.Fatalf("Error writing manifest: %v", err)
+	}
+	log.Println("Successfully wrote manifest to:", filepath.Join(outputDir, manifestFileName))
+
+	// Write checksums
+	if err := writeChecksums(outputDir); err != nil {
+		log.Fatalf("Error writing checksums: %v", err)
+	}
+	log.Println("Successfully wrote checksums to:", filepath.Join(outputDir, checksumsFileName))
+
+	// Publish the build directory
+	if config.Publish != nil {
+		if err := config.Publish.publish(outputDir, manifest); err != nil {
+			log.Fatalf("Error publishing build: %v", err)
+		}
+		log.Println("Successfully published build to bucket:", config.Publish.Bucket)
+	}
+}
+
+// artifact describes a built binary for the manifest
+func (config *BuildConfig) artifact(outputDir, outputFile, goos string, source Source, module *goModule) buildArtifact {
+	artifact, err := newBuildArtifact(outputDir, outputFile, goos, source.SourceFile, module)
+	if err != nil {
+		log.Printf("Warning: %v\n", err)
+	}
+	return artifact
 }
 
-// updateAndCopyConfigFile updates and copies the config file
-func updateAndCopyConfigFile(src, dst, defaultMode string, addAppOnConfig bool) error {
+// updateAndCopyConfigFile updates and copies the config file.
+// Placeholders are resolved only when templateVars is not nil.
+func updateAndCopyConfigFile(src, dst, defaultMode string, addAppOnConfig bool, templateVars map[string]string) error {
 	// Read the config file
 	input, err := os.ReadFile(src)
 	if err != nil {
@@ -147,6 +284,14 @@ func updateAndCopyConfigFile(src, dst, defaultMode string, addAppOnConfig bool)
 	// Convert the file content to a string
 	content := string(input)
 
+	// Resolve template placeholders
+	if templateVars != nil {
+		content, err = renderConfigTemplate(content, defaultMode, templateVars)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Check if [app] section exists, if not, add it
 	if addAppOnConfig {
 		appSectionRegex := regexp.MustCompile(`(?m)^\[app\]`)
@@ -182,9 +327,35 @@ func updateAndCopyConfigFile(src, dst, defaultMode string, addAppOnConfig bool)
 	return nil
 }
 
-// buildForOS builds the project for the given OS
-func buildForOS(goos, outputFile, sourceFile, outputDir string) error {
-	cmd := exec.Command("go", "build", "-o", filepath.Join(outputDir, outputFile), sourceFile)
+// buildForOS builds the project for the given OS inside the module directory.
+// overlay is the go build -overlay file of generated sources, extraFiles are compiled together
+// with sourceFile when it is a single Go file.
+func buildForOS(goos, outputFile, sourceFile, outputDir, moduleDir, ldflags, overlay string, extraFiles ...string) error {
+	outputPath, err := filepath.Abs(filepath.Join(outputDir, outputFile))
+	if err != nil {
+		return fmt.Errorf("error resolving output path: %v", err)
+	}
+	sourcePath, err := filepath.Abs(sourceFile)
+	if err != nil {
+		return fmt.Errorf("error resolving source path: %v", err)
+	}
+	args := []string{"build", "-o", outputPath}
+	if ldflags != "" {
+		args = append(args, "-ldflags", ldflags)
+	}
+	if overlay != "" {
+		args = append(args, "-overlay", overlay)
+	}
+	args = append(args, sourcePath)
+	for _, file := range extraFiles {
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("error resolving source path: %v", err)
+		}
+		args = append(args, absFile)
+	}
+	cmd := exec.Command("go", args...)
+	cmd.Dir = moduleDir
 	cmd.Env = append(os.Environ(), fmt.Sprintf("GOOS=%s", goos), "GOARCH=amd64")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -0,0 +1,214 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// changelogFileName and releaseNotesFileName are written into the build directory
+const (
+	changelogFileName    = "CHANGELOG.md"
+	releaseNotesFileName = "release-notes.json"
+)
+
+// conventionalCommitRegex parses subjects like "feat(api)!: add endpoint"
+var conventionalCommitRegex = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+
+// commitGroups defines the order and titles of the changelog sections
+var commitGroups = []struct {
+	Type  string
+	Title string
+}{
+	{"feat", "Features"},
+	{"fix", "Bug Fixes"},
+	{"perf", "Performance Improvements"},
+	{"refactor", "Code Refactoring"},
+	{"revert", "Reverts"},
+	{"docs", "Documentation"},
+	{"test", "Tests"},
+	{"build", "Build System"},
+	{"ci", "Continuous Integration"},
+	{"style", "Styles"},
+	{"chore", "Chores"},
+	{"other", "Other Changes"},
+}
+
+// releaseNotes is the content of release-notes.json
+type releaseNotes struct {
+	Tag             string         `json:"tag"`
+	Version         string         `json:"version"`
+	PreviousVersion string         `json:"previousVersion,omitempty"`
+	Date            string         `json:"date"`
+	VersionVariable string         `json:"versionVariable,omitempty"`
+	Breaking        []commitInfo   `json:"breaking,omitempty"`
+	Groups          []releaseGroup `json:"groups"`
+}
+
+// releaseGroup is a set of commits of the same conventional type
+type releaseGroup struct {
+	Type    string       `json:"type"`
+	Title   string       `json:"title"`
+	Commits []commitInfo `json:"commits"`
+}
+
+// commitInfo is a parsed commit
+type commitInfo struct {
+	Hash        string `json:"hash"`
+	Type        string `json:"type"`
+	Scope       string `json:"scope,omitempty"`
+	Description string `json:"description"`
+	Breaking    bool   `json:"breaking,omitempty"`
+}
+
+// gitOutput runs git in dir and returns its trimmed output
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// detectVersion returns the version of the working tree from git, or "dev" outside a repository
+func detectVersion(dir string) string {
+	version, err := gitOutput(dir, "describe", "--tags", "--always", "--dirty")
+	if err != nil || version == "" {
+		return "dev"
+	}
+	return version
+}
+
+// currentTag returns the tag pointing at HEAD, or an empty string when HEAD is not tagged
+func currentTag(dir string) string {
+	tag, err := gitOutput(dir, "describe", "--exact-match", "--tags", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return tag
+}
+
+// generateReleaseNotes collects the commits since the previous tag and writes
+// CHANGELOG.md and release-notes.json into the build directory
+func generateReleaseNotes(dir, outputDir, tag, version, versionVariable string) error {
+	previousTag, _ := gitOutput(dir, "describe", "--tags", "--abbrev=0", tag+"^")
+
+	revisionRange := tag
+	if previousTag != "" {
+		revisionRange = previousTag + ".." + tag
+	}
+	output, err := gitOutput(dir, "log", "--format=%H%x1f%s%x1f%b%x1e", revisionRange)
+	if err != nil {
+		return fmt.Errorf("error reading git history: %v", err)
+	}
+
+	notes := &releaseNotes{
+		Tag:             tag,
+		Version:         version,
+		PreviousVersion: previousTag,
+		Date:            time.Now().Format("2006-01-02"),
+		VersionVariable: versionVariable,
+	}
+
+	byType := make(map[string][]commitInfo)
+	for _, record := range strings.Split(output, "\x1e") {
+		fields := strings.Split(strings.TrimSpace(record), "\x1f")
+		if len(fields) < 2 {
+			continue
+		}
+		commit := parseCommit(fields[0], fields[1])
+		if len(fields) > 2 && strings.Contains(fields[2], "BREAKING CHANGE") {
+			commit.Breaking = true
+		}
+		if commit.Breaking {
+			notes.Breaking = append(notes.Breaking, commit)
+		}
+		byType[commit.Type] = append(byType[commit.Type], commit)
+	}
+
+	for _, group := range commitGroups {
+		if commits := byType[group.Type]; len(commits) > 0 {
+			notes.Groups = append(notes.Groups, releaseGroup{Type: group.Type, Title: group.Title, Commits: commits})
+		}
+	}
+
+	data, err := json.MarshalIndent(notes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding release notes: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, releaseNotesFileName), data, 0644); err != nil {
+		return fmt.Errorf("error writing release notes: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, changelogFileName), []byte(renderChangelog(notes)), 0644); err != nil {
+		return fmt.Errorf("error writing changelog: %v", err)
+	}
+	return nil
+}
+
+// parseCommit parses a conventional commit subject, unknown types are grouped as "other"
+func parseCommit(hash, subject string) commitInfo {
+	commit := commitInfo{Hash: hash, Type: "other", Description: subject}
+	match := conventionalCommitRegex.FindStringSubmatch(subject)
+	if match == nil {
+		return commit
+	}
+
+	commitType := strings.ToLower(match[1])
+	for _, group := range commitGroups {
+		if group.Type == commitType {
+			commit.Type = commitType
+			break
+		}
+	}
+	commit.Scope = match[2]
+	commit.Breaking = match[3] == "!"
+	commit.Description = match[4]
+	return commit
+}
+
+// renderChangelog renders the release notes as markdown
+func renderChangelog(notes *releaseNotes) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# %s (%s)\n\n", notes.Tag, notes.Date)
+	if notes.VersionVariable != "" {
+		fmt.Fprintf(&out, "Version injected into the binary: `%s` (`%s`)\n\n", notes.Version, notes.VersionVariable)
+	}
+	if notes.PreviousVersion != "" {
+		fmt.Fprintf(&out, "Changes since %s.\n\n", notes.PreviousVersion)
+	}
+
+	if len(notes.Breaking) > 0 {
+		out.WriteString("## Breaking Changes\n\n")
+		for _, commit := range notes.Breaking {
+			out.WriteString(changelogLine(commit))
+		}
+		out.WriteString("\n")
+	}
+	for _, group := range notes.Groups {
+		fmt.Fprintf(&out, "## %s\n\n", group.Title)
+		for _, commit := range group.Commits {
+			out.WriteString(changelogLine(commit))
+		}
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// changelogLine renders a commit as a markdown list item
+func changelogLine(commit commitInfo) string {
+	hash := commit.Hash
+	if len(hash) > 7 {
+		hash = hash[:7]
+	}
+	if commit.Scope != "" {
+		return fmt.Sprintf("- **%s:** %s (%s)\n", commit.Scope, commit.Description, hash)
+	}
+	return fmt.Sprintf("- %s (%s)\n", commit.Description, hash)
+}
@@ -0,0 +1,110 @@
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// embedFileName is the name of the generated Go file in the package of the source file.
+// The file only exists in the build overlay, it is never written to the source directory.
+const embedFileName = "zz_fastgo_embedded_config.go"
+
+// embeddedImportPath is the runtime package used by the generated file
+const embeddedImportPath = "github.com/raulbondarchuk/fast-go/builder/embedded"
+
+// embedOverlay is a generated Go file added to a package through go build -overlay
+type embedOverlay struct {
+	tempDir string // directory of the generated file and the overlay, removed after the build
+	overlay string // path of the overlay JSON passed to go build
+	file    string // path of the generated file in the package directory, it does not exist on disk
+}
+
+// remove deletes the generated files
+func (o *embedOverlay) remove() {
+	if o != nil {
+		_ = os.RemoveAll(o.tempDir)
+	}
+}
+
+// generateEmbedFile generates a Go file registering the config content in the package of sourceFile.
+// The file is written to a temporary directory and mapped into the package by an overlay, so an
+// interrupted build leaves nothing behind in the sources. The overlay must be removed after the build.
+func generateEmbedFile(sourceFile, configName string, content []byte) (*embedOverlay, error) {
+	dir := sourceFile
+	if strings.HasSuffix(sourceFile, ".go") {
+		dir = filepath.Dir(sourceFile)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %v", sourceFile, err)
+	}
+
+	packageName, err := detectPackageName(sourceFile, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var code strings.Builder
+	code.WriteString("// Code generated by fast-go builder. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&code, "package %s\n\n", packageName)
+	fmt.Fprintf(&code, "import %q\n\n", embeddedImportPath)
+	code.WriteString("func init() {\n")
+	fmt.Fprintf(&code, "\tembedded.Register(%q, []byte(%s))\n", configName, strconv.Quote(string(content)))
+	code.WriteString("}\n")
+
+	tempDir, err := os.MkdirTemp("", "fastgo-embed-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating embedded config dir: %v", err)
+	}
+	overlay := &embedOverlay{
+		tempDir: tempDir,
+		overlay: filepath.Join(tempDir, "overlay.json"),
+		file:    filepath.Join(dir, embedFileName),
+	}
+	generated := filepath.Join(tempDir, embedFileName)
+	if err := os.WriteFile(generated, []byte(code.String()), 0644); err != nil {
+		overlay.remove()
+		return nil, fmt.Errorf("error writing embedded config file: %v", err)
+	}
+	data, err := json.Marshal(map[string]map[string]string{"Replace": {overlay.file: generated}})
+	if err == nil {
+		err = os.WriteFile(overlay.overlay, data, 0644)
+	}
+	if err != nil {
+		overlay.remove()
+		return nil, fmt.Errorf("error writing build overlay: %v", err)
+	}
+	log.Println("Generated embedded config file:", generated)
+	return overlay, nil
+}
+
+// detectPackageName returns the package name of the source file or of the first Go file in dir
+func detectPackageName(sourceFile, dir string) (string, error) {
+	file := sourceFile
+	if !strings.HasSuffix(file, ".go") {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil || len(matches) == 0 {
+			return "", fmt.Errorf("no Go files found in %s", dir)
+		}
+		file = matches[0]
+		for _, match := range matches {
+			if !strings.HasSuffix(match, "_test.go") && filepath.Base(match) != embedFileName {
+				file = match
+				break
+			}
+		}
+	}
+
+	parsed, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly)
+	if err != nil {
+		return "", fmt.Errorf("error parsing %s: %v", file, err)
+	}
+	return parsed.Name.Name, nil
+}
@@ -0,0 +1,76 @@
+// Package embedded gives access to the config file embedded into the binary by
+// the fast-go builder when BuildConfig.EmbedConfig is enabled.
+//
+// Example of use
+/*
+func loadConfig() ([]byte, error) {
+	// config.toml next to the binary overrides the embedded copy
+	return embedded.Load("config.toml")
+}
+*/
+package embedded
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	mu       sync.RWMutex
+	fileName string
+	content  []byte
+)
+
+// Register stores the embedded config. It is called from the code generated by the builder.
+func Register(name string, data []byte) {
+	mu.Lock()
+	defer mu.Unlock()
+	fileName = name
+	content = data
+}
+
+// Available reports whether a config was embedded into the binary
+func Available() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return content != nil
+}
+
+// Name returns the file name of the embedded config, for example "config.toml"
+func Name() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return fileName
+}
+
+// Data returns a copy of the embedded config
+func Data() []byte {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]byte(nil), content...)
+}
+
+// Load returns the config from path if the file exists on disk, otherwise the embedded config.
+// A relative path is resolved against the directory of the running binary, not the working directory.
+func Load(path string) ([]byte, error) {
+	if path != "" {
+		if !filepath.IsAbs(path) {
+			if exe, err := os.Executable(); err == nil {
+				path = filepath.Join(filepath.Dir(exe), path)
+			}
+		}
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return data, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading config override %s: %w", path, err)
+		}
+	}
+	if !Available() {
+		return nil, fmt.Errorf("config file %s not found and no config is embedded", path)
+	}
+	return Data(), nil
+}
@@ -0,0 +1,94 @@
+package builder
+
+import (
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestFileName is the name of the manifest written into the build directory
+const manifestFileName = "manifest.json"
+
+// buildManifest describes the artifacts of a build
+type buildManifest struct {
+	Mode       string          `json:"mode"`
+	Version    string          `json:"version"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	Workspace  string          `json:"workspace,omitempty"`
+	ConfigFile string          `json:"configFile,omitempty"`
+	Artifacts  []buildArtifact `json:"artifacts"`
+}
+
+// buildArtifact describes a single binary of the build
+type buildArtifact struct {
+	File         string          `json:"file"`
+	GOOS         string          `json:"goos"`
+	GOARCH       string          `json:"goarch"`
+	SHA256       string          `json:"sha256"`
+	SourceFile   string          `json:"sourceFile"`
+	GoVersion    string          `json:"goVersion,omitempty"`
+	Module       moduleVersion   `json:"module"`
+	Dependencies []moduleVersion `json:"dependencies,omitempty"`
+}
+
+// moduleVersion is a module path with the version linked into the binary
+type moduleVersion struct {
+	Path    string `json:"path"`
+	Version string `json:"version,omitempty"`
+	Dir     string `json:"dir,omitempty"`
+	Replace string `json:"replace,omitempty"`
+}
+
+// newBuildArtifact reads the module versions embedded into the binary by the go toolchain
+func newBuildArtifact(outputDir, outputFile, goos, sourceFile string, module *goModule) (buildArtifact, error) {
+	binaryPath := filepath.Join(outputDir, outputFile)
+	artifact := buildArtifact{
+		File:       filepath.ToSlash(outputFile),
+		GOOS:       goos,
+		GOARCH:     "amd64",
+		SourceFile: sourceFile,
+		Module:     moduleVersion{Path: module.Path, Dir: module.Dir},
+	}
+
+	sum, err := fileSHA256(binaryPath)
+	if err != nil {
+		return artifact, err
+	}
+	artifact.SHA256 = sum
+
+	info, err := buildinfo.ReadFile(binaryPath)
+	if err != nil {
+		return artifact, fmt.Errorf("error reading build info of %s: %v", binaryPath, err)
+	}
+	artifact.GoVersion = info.GoVersion
+	if info.Main.Version != "" {
+		artifact.Module.Version = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		version := moduleVersion{Path: dep.Path, Version: dep.Version}
+		if dep.Replace != nil {
+			version.Replace = dep.Replace.Path
+			if dep.Replace.Version != "" && dep.Replace.Version != "(devel)" {
+				version.Replace += "@" + dep.Replace.Version
+			}
+		}
+		artifact.Dependencies = append(artifact.Dependencies, version)
+	}
+	return artifact, nil
+}
+
+// writeManifest writes the manifest into the build directory
+func writeManifest(outputDir string, manifest *buildManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %v", err)
+	}
+	path := filepath.Join(outputDir, manifestFileName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	return nil
+}
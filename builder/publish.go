@@ -0,0 +1,252 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// checksumsFileName is the name of the checksums file written into the build directory
+const checksumsFileName = "checksums.txt"
+
+// PublishConfig is the configuration for uploading the build directory to S3-compatible storage
+type PublishConfig struct {
+	Endpoint        string // endpoint of the S3-compatible storage. For example: "http://localhost:9000" for MinIO. Empty for AWS S3
+	Region          string // region of the bucket. For example: "us-east-1"
+	Bucket          string // name of the bucket
+	AccessKeyID     string // access key. Empty to use the default AWS credential chain
+	SecretAccessKey string // secret key
+	ForcePathStyle  bool   // true if is necessary use path-style URLs (required by MinIO)
+	KeyPrefix       string // key prefix with {version}, {mode} and {target} placeholders. Default: "{version}/{mode}/{target}"
+	LatestKey       string // key of the "latest" pointer object with the same placeholders. Default: "{mode}/{target}/latest.json"
+}
+
+// latestPointer is the content of the "latest" pointer object
+type latestPointer struct {
+	Version   string    `json:"version"`
+	Mode      string    `json:"mode"`
+	Target    string    `json:"target"`
+	Prefix    string    `json:"prefix"`
+	Manifest  string    `json:"manifest"`
+	Checksums string    `json:"checksums"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// validate validates the publish configuration
+func (config *PublishConfig) validate() error {
+	if config.Bucket == "" {
+		return fmt.Errorf("Publish.Bucket is required")
+	}
+	if config.Region == "" {
+		return fmt.Errorf("Publish.Region is required")
+	}
+	if (config.AccessKeyID == "") != (config.SecretAccessKey == "") {
+		return fmt.Errorf("Publish.AccessKeyID and Publish.SecretAccessKey must be set together")
+	}
+	return nil
+}
+
+// expandKey replaces the {version}, {mode} and {target} placeholders of a key template
+func expandKey(template, version, mode, target string) string {
+	replacer := strings.NewReplacer("{version}", version, "{mode}", mode, "{target}", target)
+	return strings.Trim(path.Clean(replacer.Replace(template)), "/")
+}
+
+// publish uploads the build directory, then the checksums and the manifest, and finally updates the "latest" pointer
+func (config *PublishConfig) publish(outputDir string, manifest *buildManifest) error {
+	sessionConfig := &aws.Config{
+		Region:           aws.String(config.Region),
+		S3ForcePathStyle: aws.Bool(config.ForcePathStyle),
+	}
+	if config.Endpoint != "" {
+		sessionConfig.Endpoint = aws.String(config.Endpoint)
+	}
+	if config.AccessKeyID != "" {
+		sessionConfig.Credentials = credentials.NewStaticCredentials(config.AccessKeyID, config.SecretAccessKey, "")
+	}
+	sess, err := session.NewSession(sessionConfig)
+	if err != nil {
+		return fmt.Errorf("error creating S3 session: %v", err)
+	}
+	uploader := s3manager.NewUploader(sess)
+
+	target := manifestTarget(manifest)
+	keyPrefix := config.KeyPrefix
+	if keyPrefix == "" {
+		keyPrefix = "{version}/{mode}/{target}"
+	}
+	prefix := expandKey(keyPrefix, manifest.Version, manifest.Mode, target)
+
+	files, err := listFiles(outputDir)
+	if err != nil {
+		return err
+	}
+
+	// The manifest and the checksums are uploaded last, so a reader never sees them before the artifacts
+	last := []string{checksumsFileName, manifestFileName}
+	var ordered []string
+	for _, file := range files {
+		if file != checksumsFileName && file != manifestFileName {
+			ordered = append(ordered, file)
+		}
+	}
+	for _, file := range last {
+		if _, err := os.Stat(filepath.Join(outputDir, file)); err == nil {
+			ordered = append(ordered, file)
+		}
+	}
+
+	for _, file := range ordered {
+		key := path.Join(prefix, file)
+		if err := uploadFile(uploader, config.Bucket, key, filepath.Join(outputDir, filepath.FromSlash(file))); err != nil {
+			return err
+		}
+		log.Printf("Uploaded s3://%s/%s\n", config.Bucket, key)
+	}
+
+	// Update the "latest" pointer
+	latestKey := config.LatestKey
+	if latestKey == "" {
+		latestKey = "{mode}/{target}/latest.json"
+	}
+	latestKey = expandKey(latestKey, manifest.Version, manifest.Mode, target)
+	pointer, err := json.MarshalIndent(latestPointer{
+		Version:   manifest.Version,
+		Mode:      manifest.Mode,
+		Target:    target,
+		Prefix:    prefix,
+		Manifest:  path.Join(prefix, manifestFileName),
+		Checksums: path.Join(prefix, checksumsFileName),
+		UpdatedAt: time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding latest pointer: %v", err)
+	}
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(config.Bucket),
+		Key:         aws.String(latestKey),
+		Body:        strings.NewReader(string(pointer)),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading latest pointer %s: %v", latestKey, err)
+	}
+	log.Printf("Updated latest pointer s3://%s/%s\n", config.Bucket, latestKey)
+	return nil
+}
+
+// uploadFile uploads a single file
+func uploadFile(uploader *s3manager.Uploader, bucket, key, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", filePath, err)
+	}
+	defer file.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(filePath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        file,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %v", key, err)
+	}
+	return nil
+}
+
+// manifestTarget returns the targets of the build joined by "-", for example "linux-windows"
+func manifestTarget(manifest *buildManifest) string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, artifact := range manifest.Artifacts {
+		if !seen[artifact.GOOS] {
+			seen[artifact.GOOS] = true
+			targets = append(targets, artifact.GOOS)
+		}
+	}
+	sort.Strings(targets)
+	return strings.Join(targets, "-")
+}
+
+// listFiles returns the files of the directory as sorted slash-separated relative paths
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(filePath string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %v", dir, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// writeChecksums writes the SHA-256 checksums of every file of the build directory in sha256sum format
+func writeChecksums(outputDir string) error {
+	files, err := listFiles(outputDir)
+	if err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	for _, file := range files {
+		if file == checksumsFileName {
+			continue
+		}
+		sum, err := fileSHA256(filepath.Join(outputDir, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&out, "%s  %s\n", sum, file)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, checksumsFileName), []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("error writing checksums: %v", err)
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of a file
+func fileSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("error opening %s: %v", filePath, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("error reading %s: %v", filePath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,293 @@
+package builder
+
+import (
+	"debug/elf"
+	"debug/pe"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sizeReportFileName is the name of the size report written into the build directory
+const sizeReportFileName = "size-report.json"
+
+// topSymbolsCount is the number of the biggest symbols kept in the report
+const topSymbolsCount = 50
+
+// binarySize is the size breakdown of a single binary
+type binarySize struct {
+	File       string       `json:"file"`
+	FileSize   int64        `json:"fileSize"`
+	SymbolSize int64        `json:"symbolSize"`
+	Packages   []symbolSize `json:"packages"`
+	TopSymbols []symbolSize `json:"topSymbols"`
+}
+
+// symbolSize is the size of a package or a symbol
+type symbolSize struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// sizeReport is the size breakdown of every binary of a build
+type sizeReport struct {
+	Binaries []binarySize `json:"binaries"`
+}
+
+// symbol is a symbol read from the binary symbol table
+type symbol struct {
+	name string
+	size int64
+}
+
+// analyzeBinarySize breaks down the size of the binary by package and symbol using its symbol table
+func analyzeBinarySize(outputDir, outputFile string) (binarySize, error) {
+	path := filepath.Join(outputDir, outputFile)
+	result := binarySize{File: filepath.ToSlash(outputFile)}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return result, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	result.FileSize = info.Size()
+
+	symbols, err := readSymbols(path)
+	if err != nil {
+		return result, err
+	}
+
+	packages := make(map[string]int64)
+	for _, sym := range symbols {
+		packages[symbolPackage(sym.name)] += sym.size
+		result.SymbolSize += sym.size
+	}
+	for name, size := range packages {
+		result.Packages = append(result.Packages, symbolSize{Name: name, Size: size})
+	}
+	sortSizes(result.Packages)
+
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].size > symbols[j].size })
+	for i := 0; i < len(symbols) && i < topSymbolsCount; i++ {
+		result.TopSymbols = append(result.TopSymbols, symbolSize{Name: symbols[i].name, Size: symbols[i].size})
+	}
+	return result, nil
+}
+
+// readSymbols reads the symbol table of an ELF or PE binary
+func readSymbols(path string) ([]symbol, error) {
+	if elfFile, err := elf.Open(path); err == nil {
+		defer elfFile.Close()
+		elfSymbols, err := elfFile.Symbols()
+		if err != nil {
+			return nil, fmt.Errorf("error reading symbols of %s: %v", path, err)
+		}
+		symbols := make([]symbol, 0, len(elfSymbols))
+		for _, sym := range elfSymbols {
+			// Symbols of sections without data in the file (bss) do not take space
+			if int(sym.Section) >= len(elfFile.Sections) || elfFile.Sections[sym.Section].Type == elf.SHT_NOBITS {
+				continue
+			}
+			if sym.Size > 0 {
+				symbols = append(symbols, symbol{name: sym.Name, size: int64(sym.Size)})
+			}
+		}
+		return symbols, nil
+	}
+
+	peFile, err := pe.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported binary format of %s", path)
+	}
+	defer peFile.Close()
+	return peSymbols(peFile), nil
+}
+
+// peSymbols computes symbol sizes of a PE binary from the distance to the next symbol of the section
+func peSymbols(file *pe.File) []symbol {
+	bySection := make(map[int16][]*pe.Symbol)
+	for _, sym := range file.Symbols {
+		if sym.SectionNumber > 0 && int(sym.SectionNumber) <= len(file.Sections) {
+			bySection[sym.SectionNumber] = append(bySection[sym.SectionNumber], sym)
+		}
+	}
+
+	var symbols []symbol
+	for section, sectionSymbols := range bySection {
+		sort.Slice(sectionSymbols, func(i, j int) bool { return sectionSymbols[i].Value < sectionSymbols[j].Value })
+		// Only the raw data of the section takes space in the file
+		end := file.Sections[section-1].Size
+		for i, sym := range sectionSymbols {
+			next := end
+			if i+1 < len(sectionSymbols) {
+				next = min(sectionSymbols[i+1].Value, end)
+			}
+			if next > sym.Value {
+				symbols = append(symbols, symbol{name: sym.Name, size: int64(next - sym.Value)})
+			}
+		}
+	}
+	return symbols
+}
+
+// symbolPackage returns the package of a Go symbol, for example "github.com/x/y" for "github.com/x/y.(*T).M"
+func symbolPackage(name string) string {
+	if strings.HasPrefix(name, "type:") || strings.HasPrefix(name, "type.") {
+		return "[types]"
+	}
+	if strings.HasPrefix(name, "go:") || strings.HasPrefix(name, "go.") {
+		return "[runtime data]"
+	}
+	// Type arguments and receivers may contain other import paths, for example
+	// "x/y.F[go.shape.*z/w.T]" or "x/y.(*T).m.func1", only the path before them counts
+	path := name
+	if end := strings.IndexAny(path, "[("); end >= 0 {
+		path = path[:end]
+	}
+	lastSlash := strings.LastIndex(path, "/")
+	dot := strings.Index(name[lastSlash+1:], ".")
+	if dot < 0 {
+		return "[other]"
+	}
+	return name[:lastSlash+1+dot]
+}
+
+// sortSizes sorts sizes from the biggest to the smallest
+func sortSizes(sizes []symbolSize) {
+	sort.Slice(sizes, func(i, j int) bool {
+		if sizes[i].Size == sizes[j].Size {
+			return sizes[i].Name < sizes[j].Name
+		}
+		return sizes[i].Size > sizes[j].Size
+	})
+}
+
+// writeSizeReport writes the size report into the build directory
+func writeSizeReport(outputDir string, report *sizeReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding size report: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, sizeReportFileName), data, 0644); err != nil {
+		return fmt.Errorf("error writing size report: %v", err)
+	}
+	return nil
+}
+
+// readPreviousSizeReport reads the size report of the latest build before the current one
+func readPreviousSizeReport(outputDir string) (*sizeReport, string, error) {
+	buildsDir := filepath.Dir(outputDir)
+	entries, err := os.ReadDir(buildsDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading builds directory: %v", err)
+	}
+
+	current := filepath.Base(outputDir)
+	var previous []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "build-") && entry.Name() < current {
+			previous = append(previous, entry.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(previous)))
+
+	for _, name := range previous {
+		data, err := os.ReadFile(filepath.Join(buildsDir, name, sizeReportFileName))
+		if err != nil {
+			continue
+		}
+		report := &sizeReport{}
+		if err := json.Unmarshal(data, report); err != nil {
+			return nil, "", fmt.Errorf("error parsing size report of %s: %v", name, err)
+		}
+		return report, name, nil
+	}
+	return nil, "", nil
+}
+
+// compareSizeReports logs the size changes against the previous build and
+// returns the binaries whose growth exceeds the budget in percent
+func compareSizeReports(previous, current *sizeReport, budgetPercent float64) []string {
+	previousBinaries := make(map[string]binarySize)
+	for _, binary := range previous.Binaries {
+		previousBinaries[binary.File] = binary
+	}
+
+	var exceeded []string
+	for _, binary := range current.Binaries {
+		old, ok := previousBinaries[binary.File]
+		if !ok || old.FileSize == 0 {
+			continue
+		}
+		growth := float64(binary.FileSize-old.FileSize) / float64(old.FileSize) * 100
+		log.Printf("Binary %s size: %d -> %d bytes (%+.2f%%)\n", binary.File, old.FileSize, binary.FileSize, growth)
+
+		for _, change := range packageGrowth(old, binary, 5) {
+			log.Printf("    %s: %+d bytes\n", change.Name, change.Size)
+		}
+
+		if budgetPercent > 0 && growth > budgetPercent {
+			exceeded = append(exceeded, fmt.Sprintf("%s grew by %.2f%% (budget %.2f%%)", binary.File, growth, budgetPercent))
+		}
+	}
+	return exceeded
+}
+
+// packageGrowth returns the packages that grew the most between two builds of a binary
+func packageGrowth(old, current binarySize, limit int) []symbolSize {
+	oldPackages := make(map[string]int64)
+	for _, pkg := range old.Packages {
+		oldPackages[pkg.Name] = pkg.Size
+	}
+
+	var changes []symbolSize
+	for _, pkg := range current.Packages {
+		if delta := pkg.Size - oldPackages[pkg.Name]; delta > 0 {
+			changes = append(changes, symbolSize{Name: pkg.Name, Size: delta})
+		}
+	}
+	sortSizes(changes)
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes
+}
+
+// checkBinarySizes writes the size report of the build and compares it with the previous build
+func (config *BuildConfig) checkBinarySizes(outputDir string, artifacts []buildArtifact) {
+	report := &sizeReport{}
+	for _, artifact := range artifacts {
+		binary, err := analyzeBinarySize(outputDir, filepath.FromSlash(artifact.File))
+		if err != nil {
+			log.Printf("Warning: %v\n", err)
+		}
+		report.Binaries = append(report.Binaries, binary)
+	}
+	if err := writeSizeReport(outputDir, report); err != nil {
+		log.Fatalf("Error writing size report: %v", err)
+	}
+	log.Println("Successfully wrote size report to:", filepath.Join(outputDir, sizeReportFileName))
+
+	previous, previousName, err := readPreviousSizeReport(outputDir)
+	if err != nil {
+		log.Printf("Warning: %v\n", err)
+		return
+	}
+	if previous == nil {
+		log.Println("No previous size report found, skipping size comparison")
+		return
+	}
+
+	log.Println("Comparing binary sizes with", previousName)
+	exceeded := compareSizeReports(previous, report, config.SizeBudgetPercent)
+	if len(exceeded) == 0 {
+		return
+	}
+	if config.SizeBudgetFail {
+		log.Fatalf("Size budget exceeded: %s", strings.Join(exceeded, "; "))
+	}
+	log.Printf("Warning: size budget exceeded: %s\n", strings.Join(exceeded, "; "))
+}
@@ -0,0 +1,184 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// placeholderRegex matches ${VAR} and ${VAR:-default} placeholders, and the $${ escape of a literal ${
+var placeholderRegex = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// loadTemplateVars collects the variables used to resolve config placeholders.
+// Sources are applied in order: env files, the per-mode values file and then the
+// process environment, so a variable exported in the shell always wins.
+func (config *BuildConfig) loadTemplateVars() (map[string]string, error) {
+	vars := make(map[string]string)
+
+	for _, file := range config.EnvFiles {
+		if err := readEnvFile(file, vars, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ValuesFile != "" {
+		valuesFile := strings.ReplaceAll(config.ValuesFile, "{mode}", config.DefaultMode)
+		if err := readEnvFile(valuesFile, vars, true); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			vars[key] = value
+		}
+	}
+	return vars, nil
+}
+
+// readEnvFile reads KEY=VALUE pairs from a .env style file into vars.
+// A missing file is ignored unless required is true.
+func readEnvFile(path string, vars map[string]string, required bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return fmt.Errorf("error reading env file %s: %v", path, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("invalid line %d in env file %s: %q", lineNumber, path, line)
+		}
+		key = strings.TrimSpace(key)
+		value, err = parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("invalid line %d in env file %s: %v", lineNumber, path, err)
+		}
+		vars[key] = value
+	}
+	return scanner.Err()
+}
+
+// parseEnvValue returns the value of a .env line. Single-quoted values are literal, double-quoted
+// values support \n, \t, \", \\ and \$ escapes, and a # after whitespace starts a comment
+// in unquoted values or after the closing quote.
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	quote := value[0]
+	if quote != '"' && quote != '\'' {
+		for i := 1; i < len(value); i++ {
+			if value[i] == '#' && (value[i-1] == ' ' || value[i-1] == '\t') {
+				return strings.TrimSpace(value[:i]), nil
+			}
+		}
+		if strings.HasPrefix(value, "#") {
+			return "", nil
+		}
+		return value, nil
+	}
+
+	var out strings.Builder
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		if c == quote {
+			rest := strings.TrimSpace(value[i+1:])
+			if rest != "" && !strings.HasPrefix(rest, "#") {
+				return "", fmt.Errorf("unexpected text after the closing quote: %q", rest)
+			}
+			return out.String(), nil
+		}
+		if c == '\\' && quote == '"' && i+1 < len(value) {
+			i++
+			switch value[i] {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			case '"', '\\', '$':
+				out.WriteByte(value[i])
+			default:
+				out.WriteByte('\\')
+				out.WriteByte(value[i])
+			}
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return "", fmt.Errorf("missing closing quote")
+}
+
+// renderConfigTemplate resolves ${VAR}, ${VAR:-default} and {{ .Env.VAR }} placeholders.
+// Placeholders without a default are required and cause an error when unresolved; $${ is a literal ${.
+// Resolved values are inserted as they are: a value containing {{ or ${ is never expanded.
+func renderConfigTemplate(content, mode string, vars map[string]string) (string, error) {
+	templated := strings.Contains(content, "{{")
+	missing := make(map[string]struct{})
+	var values []string
+
+	content = placeholderRegex.ReplaceAllStringFunc(content, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		groups := placeholderRegex.FindStringSubmatch(match)
+		value, ok := vars[groups[1]]
+		if !ok {
+			if groups[2] == "" {
+				missing[groups[1]] = struct{}{}
+				return match
+			}
+			value = groups[3]
+		}
+		if !templated {
+			return value
+		}
+		// The template inserts the value, so it is not parsed as part of the template
+		values = append(values, value)
+		return fmt.Sprintf("{{index .Placeholders %d}}", len(values)-1)
+	})
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unresolved required variables: %s", strings.Join(names, ", "))
+	}
+
+	if !templated {
+		return content, nil
+	}
+
+	tmpl, err := template.New("config").Option("missingkey=error").Parse(content)
+	if err != nil {
+		return "", fmt.Errorf("error parsing config template: %v", err)
+	}
+	var out bytes.Buffer
+	data := map[string]any{"Env": vars, "Mode": mode, "Placeholders": values}
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("error rendering config template: %v", err)
+	}
+	return out.String(), nil
+}
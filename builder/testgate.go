@@ -0,0 +1,383 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Files written into the build directory by the test gate
+const (
+	testOutputFileName   = "test-output.json"
+	junitReportFileName  = "junit-report.xml"
+	coverageFileName     = "coverage.out"
+	coverageHTMLFileName = "coverage.html"
+)
+
+// testEvent is a single event of `go test -json` (see `go doc test2json`)
+type testEvent struct {
+	Time    time.Time `json:"Time"`
+	Action  string    `json:"Action"`
+	Package string    `json:"Package"`
+	Test    string    `json:"Test"`
+	Elapsed float64   `json:"Elapsed"`
+	Output  string    `json:"Output"`
+}
+
+// junitTestSuites is the root of a JUnit XML report
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite is the JUnit representation of a Go package
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is the JUnit representation of a Go test
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+// junitMessage is the failure or skip message of a test case
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+// testGateResult is the outcome of the tests
+type testGateResult struct {
+	Failed   bool    // true if a test or a package failed
+	Coverage float64 // total statement coverage in percent
+}
+
+// runTestGate runs the tests of every module and writes the JUnit and coverage reports into the build directory
+func (config *BuildConfig) runTestGate(outputDir string, modules []*goModule) (testGateResult, error) {
+	packages := config.TestPackages
+	if len(packages) == 0 {
+		packages = []string{"./..."}
+	}
+
+	var result testGateResult
+	var events []testEvent
+	var rawOutput bytes.Buffer
+	var profiles [][]byte
+
+	seen := make(map[string]bool)
+	for i, module := range modules {
+		if seen[module.Dir] {
+			continue
+		}
+		seen[module.Dir] = true
+
+		profilePath, err := filepath.Abs(filepath.Join(outputDir, fmt.Sprintf("coverage-%d.out", i)))
+		if err != nil {
+			return result, fmt.Errorf("error resolving coverage path: %v", err)
+		}
+		args := append([]string{"test", "-json", "-coverprofile=" + profilePath}, packages...)
+		cmd := exec.Command("go", args...)
+		cmd.Dir = module.Dir
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			// go test exits with a non-zero code when tests fail, the events describe the failure
+			result.Failed = true
+			if _, ok := err.(*exec.ExitError); !ok {
+				return result, fmt.Errorf("error running tests of %s: %v", module.Path, err)
+			}
+			if stderr.Len() > 0 {
+				log.Printf("go test stderr for %s:\n%s", module.Path, stderr.String())
+			}
+		}
+		rawOutput.Write(output)
+		events = append(events, parseTestEvents(output)...)
+
+		if profile, err := os.ReadFile(profilePath); err == nil {
+			profiles = append(profiles, profile)
+			_ = os.Remove(profilePath)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(outputDir, testOutputFileName), rawOutput.Bytes(), 0644); err != nil {
+		return result, fmt.Errorf("error writing test output: %v", err)
+	}
+
+	report := junitReport(events)
+	if report.Failures > 0 {
+		result.Failed = true
+	}
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return result, fmt.Errorf("error encoding JUnit report: %v", err)
+	}
+	data = append([]byte(xml.Header), data...)
+	if err := os.WriteFile(filepath.Join(outputDir, junitReportFileName), data, 0644); err != nil {
+		return result, fmt.Errorf("error writing JUnit report: %v", err)
+	}
+	log.Printf("Tests: %d, failures: %d, skipped: %d\n", report.Tests, report.Failures, report.Skipped)
+
+	profile := mergeCoverProfiles(profiles)
+	if profile == nil {
+		return result, nil
+	}
+	coveragePath := filepath.Join(outputDir, coverageFileName)
+	if err := os.WriteFile(coveragePath, profile, 0644); err != nil {
+		return result, fmt.Errorf("error writing coverage profile: %v", err)
+	}
+	result.Coverage = coverProfilePercent(profile)
+	log.Printf("Total coverage: %.1f%%\n", result.Coverage)
+
+	absCoverage, _ := filepath.Abs(coveragePath)
+	absHTML, _ := filepath.Abs(filepath.Join(outputDir, coverageHTMLFileName))
+	cmd := exec.Command("go", "tool", "cover", "-html="+absCoverage, "-o", absHTML)
+	cmd.Dir = modules[0].Dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Warning: error generating coverage HTML: %v\n%s", err, output)
+	}
+	return result, nil
+}
+
+// parseTestEvents decodes the JSON lines of `go test -json`, skipping lines that are not events
+func parseTestEvents(output []byte) []testEvent {
+	var events []testEvent
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err == nil && event.Action != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// junitReport converts test events into a JUnit report with a test suite per package
+func junitReport(events []testEvent) *junitTestSuites {
+	type testState struct {
+		action  string
+		elapsed float64
+		output  strings.Builder
+	}
+	type packageState struct {
+		action  string
+		elapsed float64
+		start   time.Time
+		output  strings.Builder
+		tests   map[string]*testState
+		order   []string
+	}
+
+	packages := make(map[string]*packageState)
+	var packageOrder []string
+	for _, event := range events {
+		pkg, ok := packages[event.Package]
+		if !ok {
+			pkg = &packageState{start: event.Time, tests: make(map[string]*testState)}
+			packages[event.Package] = pkg
+			packageOrder = append(packageOrder, event.Package)
+		}
+
+		if event.Test == "" {
+			pkg.output.WriteString(event.Output)
+			if event.Action == "pass" || event.Action == "fail" || event.Action == "skip" {
+				pkg.action = event.Action
+				pkg.elapsed = event.Elapsed
+			}
+			continue
+		}
+
+		test, ok := pkg.tests[event.Test]
+		if !ok {
+			test = &testState{}
+			pkg.tests[event.Test] = test
+			pkg.order = append(pkg.order, event.Test)
+		}
+		test.output.WriteString(event.Output)
+		if event.Action == "pass" || event.Action == "fail" || event.Action == "skip" {
+			test.action = event.Action
+			test.elapsed = event.Elapsed
+		}
+	}
+
+	report := &junitTestSuites{}
+	var totalTime float64
+	sort.Strings(packageOrder)
+	for _, name := range packageOrder {
+		pkg := packages[name]
+		suite := junitTestSuite{Name: name, Time: formatSeconds(pkg.elapsed)}
+		if !pkg.start.IsZero() {
+			suite.Timestamp = pkg.start.Format(time.RFC3339)
+		}
+		for _, testName := range pkg.order {
+			test := pkg.tests[testName]
+			testCase := junitTestCase{Name: testName, ClassName: name, Time: formatSeconds(test.elapsed)}
+			switch test.action {
+			case "fail":
+				testCase.Failure = &junitMessage{Message: "Failed", Content: test.output.String()}
+				suite.Failures++
+			case "skip":
+				testCase.Skipped = &junitMessage{Message: "Skipped", Content: test.output.String()}
+				suite.Skipped++
+			}
+			suite.Cases = append(suite.Cases, testCase)
+			suite.Tests++
+		}
+
+		// A package failing without a failed test did not build or panicked outside a test
+		if pkg.action == "fail" && suite.Failures == 0 {
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      "[package]",
+				ClassName: name,
+				Time:      formatSeconds(pkg.elapsed),
+				Failure:   &junitMessage{Message: "Package failed", Content: pkg.output.String()},
+			})
+			suite.Tests++
+			suite.Failures++
+		}
+
+		report.Suites = append(report.Suites, suite)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+		totalTime += pkg.elapsed
+	}
+	report.Time = formatSeconds(totalTime)
+	return report
+}
+
+// formatSeconds formats seconds for JUnit time attributes
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+// mergeCoverProfiles merges coverage profiles keeping a single mode line
+func mergeCoverProfiles(profiles [][]byte) []byte {
+	var merged bytes.Buffer
+	for _, profile := range profiles {
+		for _, line := range strings.Split(string(profile), "\n") {
+			if line == "" {
+				continue
+			}
+			if strings.HasPrefix(line, "mode:") {
+				if merged.Len() == 0 {
+					merged.WriteString(line + "\n")
+				}
+				continue
+			}
+			merged.WriteString(line + "\n")
+		}
+	}
+	if merged.Len() == 0 {
+		return nil
+	}
+	return merged.Bytes()
+}
+
+// coverProfilePercent computes the total statement coverage of a profile.
+// Lines have the format "file:startLine.startCol,endLine.endCol numStatements count".
+func coverProfilePercent(profile []byte) float64 {
+	type block struct {
+		statements int
+		covered    bool
+	}
+	blocks := make(map[string]*block)
+	for _, line := range strings.Split(string(profile), "\n") {
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		statements, err1 := strconv.Atoi(fields[1])
+		count, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// The same block is reported by every package test binary that covers it
+		b, ok := blocks[fields[0]]
+		if !ok {
+			b = &block{statements: statements}
+			blocks[fields[0]] = b
+		}
+		if count > 0 {
+			b.covered = true
+		}
+	}
+
+	var total, covered int
+	for _, b := range blocks {
+		total += b.statements
+		if b.covered {
+			covered += b.statements
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) / float64(total) * 100
+}
+
+// isProdMode reports whether the build mode is treated as production by the test gate
+func (config *BuildConfig) isProdMode() bool {
+	prodModes := config.ProdModes
+	if len(prodModes) == 0 {
+		prodModes = []string{"prod"}
+	}
+	for _, mode := range prodModes {
+		if mode == config.DefaultMode {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTestGate runs the tests and refuses prod-mode builds when they fail or the coverage is too low
+func (config *BuildConfig) checkTestGate(outputDir string, modules []*goModule) {
+	result, err := config.runTestGate(outputDir, modules)
+	if err != nil {
+		log.Fatalf("Error running tests: %v", err)
+	}
+
+	var problems []string
+	if result.Failed {
+		problems = append(problems, "tests failed")
+	}
+	if config.MinCoverage > 0 && result.Coverage < config.MinCoverage {
+		problems = append(problems, fmt.Sprintf("coverage %.1f%% is below %.1f%%", result.Coverage, config.MinCoverage))
+	}
+	if len(problems) == 0 {
+		log.Println("Test gate passed")
+		return
+	}
+	if config.isProdMode() {
+		log.Fatalf("Test gate failed for %s mode: %s", config.DefaultMode, strings.Join(problems, "; "))
+	}
+	log.Printf("Warning: test gate failed: %s\n", strings.Join(problems, "; "))
+}
@@ -0,0 +1,143 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Source is an additional binary to build, possibly from another module of a go.work workspace
+type Source struct {
+	SourceFile     string // path to the source file or package
+	OutputFilename string // name of the output file
+}
+
+// goModule is a module owning one or more source files
+type goModule struct {
+	Path      string // module path from go.mod
+	Dir       string // absolute directory containing go.mod
+	GoVersion string // go directive from go.mod
+}
+
+// findModule finds the module owning the source file by walking up to the nearest go.mod
+func findModule(sourceFile string) (*goModule, error) {
+	abs, err := filepath.Abs(sourceFile)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving %s: %v", sourceFile, err)
+	}
+
+	dir := abs
+	if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+		dir = filepath.Dir(abs)
+	}
+
+	for {
+		goModPath := filepath.Join(dir, "go.mod")
+		data, err := os.ReadFile(goModPath)
+		if err == nil {
+			modFile, err := modfile.Parse(goModPath, data, nil)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing %s: %v", goModPath, err)
+			}
+			if modFile.Module == nil {
+				return nil, fmt.Errorf("no module directive in %s", goModPath)
+			}
+			module := &goModule{Path: modFile.Module.Mod.Path, Dir: dir}
+			if modFile.Go != nil {
+				module.GoVersion = modFile.Go.Version
+			}
+			return module, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading %s: %v", goModPath, err)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("no go.mod found for %s", sourceFile)
+		}
+		dir = parent
+	}
+}
+
+// findWorkspace finds the go.work file used for dir, honoring the GOWORK environment variable.
+// It returns an empty path when no workspace is used.
+func findWorkspace(dir string) (string, *modfile.WorkFile, error) {
+	path := os.Getenv("GOWORK")
+	switch path {
+	case "off":
+		return "", nil, nil
+	case "":
+		for current := dir; ; {
+			candidate := filepath.Join(current, "go.work")
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+			parent := filepath.Dir(current)
+			if parent == current {
+				return "", nil, nil
+			}
+			current = parent
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading go.work file: %v", err)
+	}
+	workFile, err := modfile.ParseWork(path, data, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("error parsing go.work file: %v", err)
+	}
+	return path, workFile, nil
+}
+
+// findModulesWorkspace returns the go.work workspace shared by the modules and checks that it lists them.
+// Modules of different workspaces, or with and without one, are rejected: go would resolve
+// their dependencies differently from what the build reports.
+func findModulesWorkspace(modules []*goModule) (string, *modfile.WorkFile, error) {
+	var workPath string
+	var workFile *modfile.WorkFile
+	for i, module := range modules {
+		path, file, err := findWorkspace(module.Dir)
+		if err != nil {
+			return "", nil, err
+		}
+		if i > 0 && path != workPath {
+			return "", nil, fmt.Errorf("module %s uses %s but module %s uses %s", module.Path, workspaceName(path), modules[0].Path, workspaceName(workPath))
+		}
+		workPath, workFile = path, file
+		if file != nil {
+			if err := checkWorkspaceModule(path, file, module); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	return workPath, workFile, nil
+}
+
+// workspaceName describes a go.work path for errors
+func workspaceName(path string) string {
+	if path == "" {
+		return "no workspace"
+	}
+	return path
+}
+
+// checkWorkspaceModule checks that the module is listed in the use directives of the workspace
+func checkWorkspaceModule(workPath string, workFile *modfile.WorkFile, module *goModule) error {
+	workDir := filepath.Dir(workPath)
+	for _, use := range workFile.Use {
+		useDir := use.Path
+		if !filepath.IsAbs(useDir) {
+			useDir = filepath.Join(workDir, useDir)
+		}
+		if filepath.Clean(useDir) == module.Dir {
+			return nil
+		}
+	}
+	return fmt.Errorf("module %s (%s) is not listed in %s", module.Path, module.Dir, workPath)
+}
@@ -0,0 +1,122 @@
+// Command fastgo-server exposes the converter over HTTP, see the server package for the routes.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/raulbondarchuk/fast-go/converter"
+	"github.com/raulbondarchuk/fast-go/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	dir := flag.String("dir", "./data", "data directory, jobs are stored in <dir>/jobs and converted files in <dir>/files")
+	workers := flag.Int("workers", 1, "concurrent background conversions")
+	presetsFile := flag.String("presets", "", "JSON or YAML file with additional presets")
+	maxImage := flag.Int64("max-image", converter.DefaultLimits.MaxInputBytes, "maximum image upload in bytes")
+	maxVideo := flag.Int64("max-video", 1<<30, "maximum video upload in bytes")
+	maxAudio := flag.Int64("max-audio", 200<<20, "maximum audio upload in bytes")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of a synchronous image conversion")
+	cacheSize := flag.Int64("cache-size", 1<<30, "size of the conversion cache in <dir>/results in bytes, 0 disables it")
+	transformSecret := flag.String("transform-secret", os.Getenv("FASTGO_TRANSFORM_SECRET"), "HMAC key of the /t/ transform URLs, empty disables them")
+	flag.Parse()
+
+	if *presetsFile != "" {
+		if err := converter.LoadPresetsFile(*presetsFile); err != nil {
+			log.Fatalf("failed to load presets: %v", err)
+		}
+	}
+
+	// Report missing tools at startup, the server still serves the formats that do not need them
+	if report := converter.HealthCheck(); !report.Healthy {
+		for _, tool := range report.Tools {
+			if tool.Error != "" || len(tool.Missing) > 0 {
+				log.Printf("warning: %s: %s %v", tool.Name, tool.Error, tool.Missing)
+			}
+		}
+	}
+
+	// Uploads of the same file with the same settings are served from the cache
+	var cache *converter.Cache
+	if *cacheSize > 0 {
+		var err error
+		if cache, err = converter.NewCache(filepath.Join(*dir, "results"), *cacheSize); err != nil {
+			log.Fatalf("failed to open the cache: %v", err)
+		}
+	}
+
+	storage := converter.NewLocalStorage(filepath.Join(*dir, "files"))
+	queue, err := converter.NewQueue(converter.QueueConfig{
+		Dir:     filepath.Join(*dir, "jobs"),
+		Storage: storage,
+		Workers: *workers,
+		Cache:   cache,
+	})
+	if err != nil {
+		log.Fatalf("failed to open the job queue: %v", err)
+	}
+
+	handler, err := server.New(server.Config{
+		Queue:   queue,
+		Storage: storage,
+		MaxUploadBytes: map[converter.FileType]int64{
+			converter.Image: *maxImage,
+			converter.Video: *maxVideo,
+			converter.Audio: *maxAudio,
+		},
+		Timeout: *timeout,
+		Cache:   cache,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	if *transformSecret != "" {
+		// Transforms of the converted files, cached in <dir>/cache
+		transform, err := server.NewTransformHandler(server.TransformConfig{
+			Secret: []byte(*transformSecret),
+			Source: storage,
+			Cache:  converter.NewLocalStorage(filepath.Join(*dir, "cache")),
+			Limits: converter.Limits{MaxInputBytes: *maxImage},
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		mux.Handle("/t/", transform)
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("listening on %s", *addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Stop on SIGINT or SIGTERM, running jobs are queued again on the next start
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	queue.Close()
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/raulbondarchuk/fast-go/converter"
+)
+
+// kindTypes maps the kind of a preset to the media type of the files it converts
+var kindTypes = map[converter.PresetKind]converter.FileType{
+	converter.PresetImage: converter.Image,
+	converter.PresetLogo:  converter.Image,
+	converter.PresetVideo: converter.Video,
+	converter.PresetAudio: converter.Audio,
+}
+
+// Status of a file in the report
+const (
+	statusConverted = "converted"
+	statusFailed    = "failed"
+	statusSkipped   = "skipped"
+)
+
+// input is a file to convert
+type input struct {
+	path string // path on disk
+	key  string // output key in the output directory, without the extension
+}
+
+// FileReport is the outcome of a file in the report
+type FileReport struct {
+	Input  string             `json:"input"`
+	Output string             `json:"output,omitempty"`
+	Type   converter.FileType `json:"type"`
+	Status string             `json:"status"`
+	Error  string             `json:"error,omitempty"`
+	Result *converter.Result  `json:"result,omitempty"`
+}
+
+// Report is the JSON report of a convert run
+type Report struct {
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt time.Time     `json:"finishedAt"`
+	Elapsed    time.Duration `json:"elapsed"`
+	Converted  int           `json:"converted"`
+	Failed     int           `json:"failed"`
+	Skipped    int           `json:"skipped"`
+	Files      []FileReport  `json:"files"`
+}
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// settingsFlags are the explicit settings of the convert command
+type settingsFlags struct {
+	imageFormat, videoFormat, audioFormat string
+	imageWidth, imageHeight, imageQuality int
+	imageStretch                          float64
+	imageTransparent                      bool
+	videoWidth, videoHeight, videoQuality int
+	audioBitrate                          int
+}
+
+// runConvert runs the convert command and returns the exit code
+func runConvert(args []string) int {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), "Usage: fastgo convert [flags] <file|dir|glob>...\n\n"+
+			"Directories are walked recursively, files of other media types are skipped.\n"+
+			"Each media type is converted with its preset or with its explicit flags.\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+
+	var presetNames stringList
+	var s settingsFlags
+	flags.Var(&presetNames, "preset", "preset to use, repeat it for different media types (image, video, audio)")
+	presetsFile := flags.String("presets", "", "JSON or YAML file with additional presets")
+	outDir := flags.String("out", "", "output directory, the input tree is mirrored in it (required)")
+	parallel := flags.Int("j", runtime.NumCPU(), "conversions running at the same time")
+	reportPath := flags.String("report", "", "path of the JSON report. Default: <out>/fastgo-report.json")
+	flags.StringVar(&s.imageFormat, "image-format", "", "output format of images: png, jpg, jpeg, webp")
+	flags.IntVar(&s.imageWidth, "image-width", 0, "target width of images")
+	flags.IntVar(&s.imageHeight, "image-height", 0, "target height of images")
+	flags.IntVar(&s.imageQuality, "image-quality", 4, "quality of images, 1-5")
+	flags.Float64Var(&s.imageStretch, "image-stretch", 0, "stretch threshold of images in percent")
+	flags.BoolVar(&s.imageTransparent, "image-transparent", false, "transparent background instead of blurred")
+	flags.StringVar(&s.videoFormat, "video-format", "", "output format of videos: mp4, webm")
+	flags.IntVar(&s.videoWidth, "video-width", 0, "target width of videos")
+	flags.IntVar(&s.videoHeight, "video-height", 0, "target height of videos")
+	flags.IntVar(&s.videoQuality, "video-quality", 3, "quality of videos, 1-5")
+	flags.StringVar(&s.audioFormat, "audio-format", "", "output format of audio: mp3, m4a, opus, wav")
+	flags.IntVar(&s.audioBitrate, "audio-bitrate", 128, "bitrate of audio in kbps")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	if *outDir == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *parallel < 1 {
+		*parallel = 1
+	}
+	if *reportPath == "" {
+		*reportPath = filepath.Join(*outDir, "fastgo-report.json")
+	}
+	if *presetsFile != "" {
+		if err := converter.LoadPresetsFile(*presetsFile); err != nil {
+			fmt.Fprintf(os.Stderr, "fastgo: failed to load presets: %v\n", err)
+			return 2
+		}
+	}
+
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	settings, err := resolveSettings(presetNames, s, explicit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fastgo: %v\n", err)
+		return 2
+	}
+
+	inputs, reports, err := collectInputs(flags.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fastgo: %v\n", err)
+		return 2
+	}
+
+	// Ctrl-C cancels the running conversions, the report is still written
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report := Report{StartedAt: time.Now()}
+	report.Files = append(reports, convertAll(ctx, inputs, settings, converter.NewLocalStorage(*outDir), *parallel)...)
+	report.FinishedAt = time.Now()
+	report.Elapsed = report.FinishedAt.Sub(report.StartedAt)
+	sort.SliceStable(report.Files, func(i, k int) bool {
+		return report.Files[i].Input < report.Files[k].Input
+	})
+	for _, file := range report.Files {
+		switch file.Status {
+		case statusConverted:
+			report.Converted++
+		case statusFailed:
+			report.Failed++
+		case statusSkipped:
+			report.Skipped++
+		}
+	}
+
+	printSummary(report)
+	if err := writeReport(*reportPath, report); err != nil {
+		fmt.Fprintf(os.Stderr, "fastgo: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Report: %s\n", *reportPath)
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// resolveSettings returns the settings of each media type from the presets and the explicit flags.
+// Explicit flags override the preset of their media type.
+func resolveSettings(presetNames []string, s settingsFlags, explicit map[string]bool) (map[converter.FileType]converter.Preset, error) {
+	settings := make(map[converter.FileType]converter.Preset)
+	for _, name := range presetNames {
+		preset, ok := converter.GetPreset(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown preset %q, available: %s", name, strings.Join(converter.PresetNames(), ", "))
+		}
+		fileType := kindTypes[preset.Kind]
+		if previous, ok := settings[fileType]; ok {
+			return nil, fmt.Errorf("presets %q and %q both convert %s files", previous.Name, name, fileType)
+		}
+		settings[fileType] = preset
+	}
+
+	// Flags of a media type are used when one of them is set
+	set := func(prefix string) bool {
+		for name := range explicit {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	groups := []struct {
+		fileType converter.FileType
+		prefix   string
+		flags    converter.Preset
+	}{
+		{converter.Image, "image-", converter.Preset{
+			Kind: converter.PresetImage, FormatToConvert: s.imageFormat, Width: s.imageWidth, Height: s.imageHeight,
+			Quality: s.imageQuality, StretchThreshold: s.imageStretch, TransparentBackground: s.imageTransparent,
+		}},
+		{converter.Video, "video-", converter.Preset{
+			Kind: converter.PresetVideo, FormatToConvert: s.videoFormat, Width: s.videoWidth, Height: s.videoHeight,
+			Quality: s.videoQuality,
+		}},
+		{converter.Audio, "audio-", converter.Preset{
+			Kind: converter.PresetAudio, FormatToConvert: s.audioFormat, Bitrate: s.audioBitrate,
+		}},
+	}
+	for _, group := range groups {
+		if !set(group.prefix) {
+			continue
+		}
+		// Without a preset the flags are the settings, defaults included
+		base, ok := settings[group.fileType]
+		if !ok {
+			base = group.flags
+			base.Name = strings.TrimSuffix(group.prefix, "-") + " flags"
+		}
+		var err error
+		if settings[group.fileType], err = base.With(overrides(group.prefix, group.flags, explicit)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(settings) == 0 {
+		return nil, errors.New("no settings: use -preset or the -image-*, -video-* or -audio-* flags")
+	}
+	return settings, nil
+}
+
+// overrides returns the fields of flags whose flag with the prefix was set, so a preset keeps the others
+func overrides(prefix string, flags converter.Preset, explicit map[string]bool) converter.PresetOverrides {
+	var o converter.PresetOverrides
+	if explicit[prefix+"format"] {
+		o.FormatToConvert = &flags.FormatToConvert
+	}
+	if explicit[prefix+"width"] {
+		o.Width = &flags.Width
+	}
+	if explicit[prefix+"height"] {
+		o.Height = &flags.Height
+	}
+	if explicit[prefix+"quality"] {
+		o.Quality = &flags.Quality
+	}
+	if explicit[prefix+"stretch"] {
+		o.StretchThreshold = &flags.StretchThreshold
+	}
+	if explicit[prefix+"transparent"] {
+		o.TransparentBackground = &flags.TransparentBackground
+	}
+	if explicit[prefix+"bitrate"] {
+		o.Bitrate = &flags.Bitrate
+	}
+	return o
+}
+
+// collectInputs expands the arguments into files. Directories are walked recursively and
+// arguments that are not files are expanded as glob patterns. Files of unknown type found in
+// directories are ignored, named files of unknown type are reported as skipped.
+// A file matched by several arguments is converted once.
+func collectInputs(args []string) ([]input, []FileReport, error) {
+	var inputs []input
+	var reports []FileReport
+	seen := make(map[string]bool)
+	add := func(filePath, key string) {
+		filePath = filepath.Clean(filePath)
+		if !seen[filePath] {
+			seen[filePath] = true
+			inputs = append(inputs, input{path: filePath, key: key})
+		}
+	}
+	for _, arg := range args {
+		paths := []string{arg}
+		if _, err := os.Stat(arg); err != nil {
+			matches, globErr := filepath.Glob(arg)
+			if globErr != nil {
+				return nil, nil, fmt.Errorf("invalid pattern %q: %w", arg, globErr)
+			}
+			if len(matches) == 0 {
+				return nil, nil, fmt.Errorf("%s: no such file or directory", arg)
+			}
+			paths = matches
+		}
+
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !info.IsDir() {
+				if converter.DetermineFileType(p) == converter.Unknown {
+					reports = append(reports, FileReport{Input: p, Status: statusSkipped, Error: "unsupported file type"})
+					continue
+				}
+				add(p, trimExt(filepath.Base(p)))
+				continue
+			}
+
+			err = filepath.WalkDir(p, func(filePath string, entry fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if entry.IsDir() || converter.DetermineFileType(filePath) == converter.Unknown {
+					return nil
+				}
+				rel, err := filepath.Rel(p, filePath)
+				if err != nil {
+					return err
+				}
+				add(filePath, trimExt(filepath.ToSlash(rel)))
+				return nil
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return inputs, reports, nil
+}
+
+// trimExt removes the extension of a file name
+func trimExt(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// convertAll converts the inputs with parallel workers
+func convertAll(ctx context.Context, inputs []input, settings map[converter.FileType]converter.Preset, storage converter.Storage, parallel int) []FileReport {
+	reports := make([]FileReport, len(inputs))
+	outputs := make(map[string]string) // output key to the input that writes it
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				in := inputs[index]
+				preset := settings[converter.DetermineFileType(in.path)]
+				reports[index] = convertFile(ctx, in, preset, storage)
+			}
+		}()
+	}
+
+	for index, in := range inputs {
+		fileType := converter.DetermineFileType(in.path)
+		reports[index] = FileReport{Input: in.path, Type: fileType}
+		preset, ok := settings[fileType]
+		if !ok {
+			reports[index].Status = statusSkipped
+			reports[index].Error = fmt.Sprintf("no settings for %s files", fileType)
+			continue
+		}
+		// Two inputs that only differ in the extension would overwrite each other
+		key := in.key + "." + preset.FormatToConvert
+		if previous, ok := outputs[key]; ok {
+			reports[index].Status = statusFailed
+			reports[index].Error = fmt.Sprintf("output %s is also written by %s", key, previous)
+			continue
+		}
+		outputs[key] = in.path
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+	return reports
+}
+
+// convertFile converts a file with the preset and puts the output into the storage
+func convertFile(ctx context.Context, in input, preset converter.Preset, storage converter.Storage) FileReport {
+	report := FileReport{Input: in.path, Type: converter.DetermineFileType(in.path), Status: statusFailed}
+	file, err := os.Open(in.path)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	defer file.Close()
+
+	key := in.key + "." + preset.FormatToConvert
+	fileName := filepath.Base(in.path)
+	conv, err := newConverter(preset, fileName, file, storage, key)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	result, err := conv.ConvertContext(ctx)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Status = statusConverted
+	report.Output = result.Path
+	report.Result = result
+	return report
+}
+
+// newConverter expands the preset into the config of its kind that puts the output into the storage
+func newConverter(preset converter.Preset, fileName string, file io.Reader, storage converter.Storage, key string) (converter.Converter, error) {
+	switch preset.Kind {
+	case converter.PresetImage:
+		config, err := preset.ImageConfig(fileName, file)
+		if err != nil {
+			return nil, err
+		}
+		config.Storage, config.Key = storage, key
+		return config, nil
+	case converter.PresetLogo:
+		config, err := preset.LogoConfig(fileName, file)
+		if err != nil {
+			return nil, err
+		}
+		config.Storage, config.Key = storage, key
+		return config, nil
+	case converter.PresetVideo:
+		config, err := preset.VideoConfig(fileName, file)
+		if err != nil {
+			return nil, err
+		}
+		config.Storage, config.Key = storage, key
+		return config, nil
+	case converter.PresetAudio:
+		config, err := preset.AudioConfig(fileName, file)
+		if err != nil {
+			return nil, err
+		}
+		config.Storage, config.Key = storage, key
+		return config, nil
+	}
+	return nil, fmt.Errorf("%w: unknown preset kind %s", converter.ErrInvalidOption, preset.Kind)
+}
+
+// printSummary prints a table of the files and the totals
+func printSummary(report Report) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tINPUT\tOUTPUT\tSIZE\tTIME\tERROR")
+	for _, file := range report.Files {
+		size, elapsed := "-", "-"
+		if file.Result != nil {
+			size = formatSize(file.Result.Size)
+			elapsed = file.Result.Elapsed.Round(time.Millisecond).String()
+		}
+		output := file.Output
+		if output == "" {
+			output = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", file.Status, file.Input, output, size, elapsed, file.Error)
+	}
+	w.Flush()
+	fmt.Printf("\n%d converted, %d failed, %d skipped in %s\n",
+		report.Converted, report.Failed, report.Skipped, report.Elapsed.Round(time.Millisecond))
+}
+
+// formatSize returns the size in B, KB, MB or GB
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// writeReport writes the report as indented JSON
+func writeReport(reportPath string, report Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(reportPath), 0755); err != nil {
+		return fmt.Errorf("failed to create report dir: %w", err)
+	}
+	if err := os.WriteFile(reportPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+// Command fastgo runs the converter from the command line.
+//
+//	fastgo convert [flags] <file|dir|glob>...
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: fastgo <command> [flags]
+
+Commands:
+  convert   convert files and directories with presets or explicit settings
+
+Run "fastgo <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "convert":
+		os.Exit(runConvert(os.Args[2:]))
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "fastgo: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,365 @@
+package converter
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheVersion is part of every cache key, it is increased when a pipeline changes its output
+const cacheVersion = 1
+
+// Cache stores converted outputs by the hash of the input and the normalized conversion options.
+// A conversion whose output is cached returns it without running imaging or ffmpeg.
+// Concurrent conversions of the same input and options run once, the others wait and use the cached output.
+// The least recently used outputs are evicted when the total size exceeds the size limit.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element // values are *cacheEntry
+	lru       *list.List               // front is the most recently used
+	size      int64
+	locks     map[string]*cacheLock
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// cacheEntry is a cached output
+type cacheEntry struct {
+	key    string
+	size   int64
+	result Result
+}
+
+// cacheLock serializes the conversions of a cache key
+type cacheLock struct {
+	mu    sync.Mutex
+	users int // conversions holding or waiting for the lock
+}
+
+// CacheStats are the statistics of a Cache
+type CacheStats struct {
+	Entries   int    `json:"entries"`   // Cached outputs
+	Size      int64  `json:"size"`      // Total size of the cached outputs in bytes
+	MaxBytes  int64  `json:"maxBytes"`  // Size limit
+	Hits      uint64 `json:"hits"`      // Conversions served from the cache
+	Misses    uint64 `json:"misses"`    // Conversions that ran
+	Evictions uint64 `json:"evictions"` // Outputs removed to stay under the size limit
+}
+
+// NewCache opens the cache stored in dir. Outputs cached by a previous process are kept,
+// their last use is restored from the modification time of the files.
+func NewCache(dir string, maxBytes int64) (*Cache, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("%w: cache dir is required", ErrInvalidOption)
+	}
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("%w: cache size must be greater than 0", ErrInvalidOption)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		locks:    make(map[string]*cacheLock),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load indexes the stored outputs, the most recently used first
+func (c *Cache) load() error {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache dir: %w", err)
+	}
+
+	type stored struct {
+		entry   *cacheEntry
+		modTime time.Time
+	}
+	var loaded []stored
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			// Left by a write that was interrupted
+			os.Remove(filepath.Join(c.dir, name))
+			continue
+		}
+		if filepath.Ext(name) != ".json" {
+			continue
+		}
+		key := strings.TrimSuffix(name, ".json")
+		data, err := os.ReadFile(c.metaPath(key))
+		var result Result
+		if err == nil {
+			err = json.Unmarshal(data, &result)
+		}
+		info, statErr := os.Stat(c.dataPath(key))
+		if err != nil || statErr != nil {
+			// Incomplete entry
+			c.remove(key)
+			continue
+		}
+		loaded = append(loaded, stored{&cacheEntry{key: key, size: info.Size(), result: result}, info.ModTime()})
+	}
+
+	sort.Slice(loaded, func(i, k int) bool {
+		return loaded[i].modTime.After(loaded[k].modTime)
+	})
+	for _, s := range loaded {
+		c.entries[s.entry.key] = c.lru.PushBack(s.entry)
+		c.size += s.entry.size
+	}
+	c.removeEvicted(c.evict())
+	return nil
+}
+
+// Stats returns the size and the hit rate of the cache
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries:   len(c.entries),
+		Size:      c.size,
+		MaxBytes:  c.maxBytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// cacheKey returns the key of the input hash and the normalized options
+func cacheKey(inputHash, options string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("v%d\n%s\n%s", cacheVersion, inputHash, options)))
+	return hex.EncodeToString(sum[:])
+}
+
+// fileHash returns the hex encoded SHA-256 of a file
+func fileHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open original: %w", err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read original: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// lock locks the key and returns the function that unlocks it
+func (c *Cache) lock(key string) func() {
+	c.mu.Lock()
+	lock, ok := c.locks[key]
+	if !ok {
+		lock = &cacheLock{}
+		c.locks[key] = lock
+	}
+	lock.users++
+	c.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		c.unlock(key, lock)
+	}
+}
+
+// unlock unlocks the key and forgets its lock when nobody else uses it
+func (c *Cache) unlock(key string, lock *cacheLock) {
+	lock.mu.Unlock()
+	c.mu.Lock()
+	lock.users--
+	if lock.users == 0 {
+		delete(c.locks, key)
+	}
+	c.mu.Unlock()
+}
+
+// get opens the cached output of the key and marks it as used.
+// The caller must hold the lock of the key until the file is closed: evict skips locked keys,
+// so the file is not deleted while it is read, which Windows would refuse anyway.
+func (c *Cache) get(key string) (*os.File, *Result, bool) {
+	c.mu.Lock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil, nil, false
+	}
+	c.lru.MoveToFront(element)
+	result := element.Value.(*cacheEntry).result
+	c.mu.Unlock()
+
+	// The entry can not change while the key is locked, the files are accessed without c.mu
+	file, err := os.Open(c.dataPath(key))
+	if err != nil {
+		c.mu.Lock()
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		c.size -= element.Value.(*cacheEntry).size
+		c.misses++
+		c.mu.Unlock()
+		return nil, nil, false
+	}
+	now := time.Now()
+	os.Chtimes(c.dataPath(key), now, now)
+
+	c.mu.Lock()
+	c.hits++
+	c.mu.Unlock()
+	return file, &result, true
+}
+
+// put stores the output of the key and evicts the least recently used outputs over the size limit.
+// Outputs larger than the limit are not cached. The caller must hold the lock of the key.
+func (c *Cache) put(key string, r io.Reader, result *Result) error {
+	if result.Size > c.maxBytes {
+		return nil
+	}
+
+	// The location belongs to the conversion, not to the cached output
+	stored := *result
+	stored.Path, stored.Key, stored.Elapsed = "", "", 0
+	meta, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+	if err := writeFileAtomic(c.dataPath(key), r); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.metaPath(key), bytes.NewReader(meta)); err != nil {
+		os.Remove(c.dataPath(key))
+		return err
+	}
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.size -= element.Value.(*cacheEntry).size
+		c.lru.Remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, size: result.Size, result: stored})
+	c.size += result.Size
+	evicted := c.evict()
+	c.mu.Unlock()
+	c.removeEvicted(evicted)
+	return nil
+}
+
+// evict removes the least recently used outputs from the index until the size is under the limit
+// and returns their keys, c.mu must be held. Locked keys are in use and skipped, so the size may
+// stay over the limit until they are unlocked. The returned keys are locked until removeEvicted
+// deletes their files, a conversion of the same key waits for it.
+func (c *Cache) evict() []string {
+	var evicted []string
+	for element := c.lru.Back(); element != nil && c.size > c.maxBytes; {
+		entry := element.Value.(*cacheEntry)
+		previous := element.Prev()
+		if _, busy := c.locks[entry.key]; !busy {
+			c.lru.Remove(element)
+			delete(c.entries, entry.key)
+			c.size -= entry.size
+			c.evictions++
+			lock := &cacheLock{users: 1}
+			lock.mu.Lock()
+			c.locks[entry.key] = lock
+			evicted = append(evicted, entry.key)
+		}
+		element = previous
+	}
+	return evicted
+}
+
+// removeEvicted deletes the files of the keys returned by evict and unlocks them, c.mu must not be held
+func (c *Cache) removeEvicted(keys []string) {
+	for _, key := range keys {
+		c.remove(key)
+		c.mu.Lock()
+		lock := c.locks[key]
+		c.mu.Unlock()
+		c.unlock(key, lock)
+	}
+}
+
+// putFile caches the output file of the key, a failed write only means that the next conversion runs again
+func (c *Cache) putFile(key, path string, result *Result) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_ = c.put(key, file, result)
+}
+
+// remove deletes the files of the key
+func (c *Cache) remove(key string) {
+	os.Remove(c.dataPath(key))
+	os.Remove(c.metaPath(key))
+}
+
+// dataPath returns the path of the cached output of the key
+func (c *Cache) dataPath(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// metaPath returns the path of the cached result of the key
+func (c *Cache) metaPath(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// convert writes the cached output of the input and options to w. Without a cached output
+// it runs render, writes its output to w and caches it. The input is read into memory to
+// hash it, so it is used for images only; its size is bounded by limits.
+func (c *Cache) convert(input io.Reader, limits Limits, options string, w io.Writer, render func(io.Reader, io.Writer) (*Result, error)) (*Result, error) {
+	start := time.Now()
+	limited := &limitedReader{r: input, max: limits.withDefaults().MaxInputBytes}
+	data, err := io.ReadAll(limited)
+	if err != nil {
+		if limitErr := limited.err(); limitErr != nil {
+			return nil, limitErr
+		}
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	key := cacheKey(hex.EncodeToString(sum[:]), options)
+
+	unlock := c.lock(key)
+	defer unlock()
+	if cached, result, ok := c.get(key); ok {
+		defer cached.Close()
+		if _, err := io.Copy(w, cached); err != nil {
+			return nil, fmt.Errorf("failed to write cached output: %w", err)
+		}
+		result.Elapsed = time.Since(start)
+		return result, nil
+	}
+
+	var output bytes.Buffer
+	result, err := render(bytes.NewReader(data), io.MultiWriter(w, &output))
+	if err != nil {
+		return nil, err
+	}
+	// A failed write only means that the next conversion runs again
+	_ = c.put(key, &output, result)
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// killWaitDelay is how long runTool waits for the output pipes after killing a process
+const killWaitDelay = 5 * time.Second
+
+// runTool runs an external tool and kills its whole process tree when ctx is done.
+// stdin, stdout and stderr may be nil. The returned error is ErrCanceled when ctx
+// is done, ErrToolMissing or *FFmpegError otherwise.
+func runTool(ctx context.Context, tool string, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	return runCommand(ctx, tool, args, stdin, stdout, stderr, nil)
+}
+
+// runCommand is runTool with a hook that is called with the pid right after the process starts.
+// The process is killed when the hook fails.
+func runCommand(ctx context.Context, tool string, args []string, stdin io.Reader, stdout, stderr io.Writer, started func(pid int) error) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(ctx, err)
+	}
+
+	var log bytes.Buffer
+	cmd := exec.CommandContext(ctx, Tools().path(tool), args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &log
+	if stderr != nil {
+		cmd.Stderr = io.MultiWriter(&log, stderr)
+	}
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessTree(cmd)
+	}
+	cmd.WaitDelay = killWaitDelay
+
+	if err := cmd.Start(); err != nil {
+		return toolError(tool, err, args, log.String())
+	}
+	if started != nil {
+		if err := started(cmd.Process.Pid); err != nil {
+			killProcessTree(cmd)
+			cmd.Wait()
+			return fmt.Errorf("failed to sandbox %s: %w", tool, err)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return contextError(ctx, err)
+		}
+		return toolError(tool, err, args, log.String())
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Sentinel errors returned by the converter. They are wrapped with details,
+// so they must be checked with errors.Is.
+var (
+	ErrUnsupportedFormat = errors.New("unsupported format")    // output or input format is not supported
+	ErrInvalidDimensions = errors.New("invalid dimensions")    // width or height is out of range
+	ErrInvalidOption     = errors.New("invalid option")        // a required field is missing or a value is out of range
+	ErrDecode            = errors.New("decode error")          // the input could not be decoded
+	ErrEncode            = errors.New("encode error")          // the output could not be encoded
+	ErrToolMissing       = errors.New("external tool missing") // ffmpeg or cwebp is not available
+	ErrCanceled          = errors.New("conversion canceled")   // the context was canceled or its deadline exceeded
+	ErrContentMismatch   = errors.New("content mismatch")      // the file extension disagrees with the detected content
+	ErrLimitExceeded     = errors.New("limit exceeded")        // the input is larger than the configured Limits
+)
+
+// FFmpegError is returned when ffmpeg exits with an error
+type FFmpegError struct {
+	Args     []string // Arguments passed to ffmpeg
+	ExitCode int      // Exit code of the process, -1 if it did not exit normally
+	Stderr   string   // Log written by ffmpeg
+	Err      error    // Underlying error
+}
+
+// Error returns the error message with the last line of the ffmpeg log
+func (e *FFmpegError) Error() string {
+	stderr := strings.TrimSpace(e.Stderr)
+	if index := strings.LastIndex(stderr, "\n"); index >= 0 {
+		stderr = stderr[index+1:]
+	}
+	if stderr == "" {
+		return fmt.Sprintf("ffmpeg exited with code %d: %v", e.ExitCode, e.Err)
+	}
+	return fmt.Sprintf("ffmpeg exited with code %d: %s", e.ExitCode, stderr)
+}
+
+// Unwrap returns the underlying error
+func (e *FFmpegError) Unwrap() error {
+	return e.Err
+}
+
+// contextError returns ErrCanceled wrapping the context error when ctx is done, otherwise err
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %w", ErrCanceled, ctxErr)
+	}
+	return err
+}
+
+// toolError converts the error of an external tool into ErrToolMissing or *FFmpegError
+func toolError(tool string, err error, args []string, stderr string) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%w: %s: %w", ErrToolMissing, tool, err)
+	}
+	if tool != "ffmpeg" {
+		return fmt.Errorf("%w: %s: %w: %s", ErrEncode, tool, err, strings.TrimSpace(stderr))
+	}
+
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+	if killedByRlimit(err) {
+		err = fmt.Errorf("%w: ffmpeg reached the CPU time or output size of its sandbox: %w", ErrLimitExceeded, err)
+	}
+	return &FFmpegError{Args: args, ExitCode: exitCode, Stderr: stderr, Err: err}
+}
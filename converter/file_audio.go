@@ -2,12 +2,13 @@ package converter
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type AudioConfig struct {
@@ -16,23 +17,32 @@ type AudioConfig struct {
 	Bitrate         int
 	FormatToConvert string // mp3, m4a, opus, wav, mp4
 	DirToStorage    string
+	Storage         Storage       // used instead of DirToStorage when set
+	Key             string        // key in the storage, default built with NameStrategy
+	NameStrategy    NameStrategy  // default NameOriginal, "processed_<name>.<format>"
+	Sandbox         Sandbox       // limits of the ffmpeg run, zero fields use DefaultSandbox
+	Progress        func(float64) // called with the converted fraction 0-1 while ffmpeg runs
+	Cache           *Cache        // cache of converted outputs, nil disables caching
 }
 
 func (c *AudioConfig) validateValues() error {
 	if c.Bitrate < 64 || c.Bitrate > 320 {
-		return fmt.Errorf("bitrate must be between 64 and 320 kbps")
+		return fmt.Errorf("%w: bitrate must be between 64 and 320 kbps", ErrInvalidOption)
 	}
-	if c.DirToStorage == "" {
-		return fmt.Errorf("dir to storage is required")
+	if c.Storage == nil && c.DirToStorage == "" {
+		return fmt.Errorf("%w: storage or dir to storage is required", ErrInvalidOption)
 	}
 	if c.FileName == "" {
-		return fmt.Errorf("file name is required")
+		return fmt.Errorf("%w: file name is required", ErrInvalidOption)
+	}
+	if err := validateFileName(c.FileName); err != nil {
+		return err
 	}
 	if c.File == nil {
-		return fmt.Errorf("file is required")
+		return fmt.Errorf("%w: file is required", ErrInvalidOption)
 	}
 	if !c.isFileExtensionSupported() {
-		return fmt.Errorf("unsupported file extension: %s", filepath.Ext(c.FileName))
+		return fmt.Errorf("%w: unsupported file extension: %s", ErrUnsupportedFormat, filepath.Ext(c.FileName))
 	}
 	return nil
 }
@@ -50,73 +60,130 @@ func (c *AudioConfig) isFileExtensionSupported() bool {
 	return false
 }
 
-func (c *AudioConfig) processAudio() (string, error) {
+func (c *AudioConfig) processAudio(ctx context.Context) (*Result, error) {
+	start := time.Now()
 	if err := c.validateValues(); err != nil {
-		return "", err
+		return nil, err
 	}
-
-	if err := os.MkdirAll(c.DirToStorage, 0755); err != nil {
-		return "", fmt.Errorf("failed to create result dir: %w", err)
+	if err := requireFormat(ctx, Audio, c.FormatToConvert); err != nil {
+		return nil, err
 	}
 
-	// Save original audio temporarily in dirResult
-	tempPath := filepath.Join(c.DirToStorage, c.FileName)
-	outFile, err := os.Create(tempPath)
+	// Save original audio in a temporary directory
+	// The demuxer of the sniffed format is forced, ffmpeg never probes the upload
+	demuxer, file, err := sniffMedia(c.FileName, c.File)
+	if err != nil {
+		return nil, err
+	}
+	workDir, tempPath, err := stageOriginal(file, c.FileName)
 	if err != nil {
-		return "", fmt.Errorf("failed to save original: %w", err)
+		return nil, err
 	}
-	defer os.Remove(tempPath) // Ensure the file is removed after processing
-	if _, err := io.Copy(outFile, c.File); err != nil {
-		outFile.Close()
-		return "", fmt.Errorf("failed to write original: %w", err)
+	defer os.RemoveAll(workDir)
+
+	// Concurrent conversions of the same input and settings wait for the first one
+	var cacheEntryKey string
+	if c.Cache != nil {
+		hash, err := fileHash(tempPath)
+		if err != nil {
+			return nil, err
+		}
+		cacheEntryKey = cacheKey(hash, c.cacheOptions())
+		unlock := c.Cache.lock(cacheEntryKey)
+		defer unlock()
+		if cached, result, ok := c.Cache.get(cacheEntryKey); ok {
+			defer cached.Close()
+			return c.storeCached(ctx, cached, result, start)
+		}
 	}
-	outFile.Close()
 
 	// Create a path for the processed file
 	filename := strings.TrimSuffix(filepath.Base(tempPath), filepath.Ext(tempPath))
-	destPath := filepath.Join(c.DirToStorage, "processed_"+filename+"."+c.FormatToConvert)
+	destPath := filepath.Join(workDir, "processed_"+filename+"."+c.FormatToConvert)
 
 	// Prepare ffmpeg args based on format
 	var args []string
+	var codec string
 	switch c.FormatToConvert {
 	case "mp3":
-		args = []string{"-i", tempPath, "-c:a", "libmp3lame", "-b:a", fmt.Sprintf("%dk", c.Bitrate), destPath}
+		codec = "libmp3lame"
+		args = []string{"-i", tempPath, "-c:a", codec, "-b:a", fmt.Sprintf("%dk", c.Bitrate), destPath}
 	case "m4a":
-		args = []string{"-i", tempPath, "-c:a", "aac", "-b:a", fmt.Sprintf("%dk", c.Bitrate), destPath}
+		codec = "aac"
+		args = []string{"-i", tempPath, "-c:a", codec, "-b:a", fmt.Sprintf("%dk", c.Bitrate), destPath}
 	case "opus":
-		args = []string{"-i", tempPath, "-c:a", "libopus", "-b:a", fmt.Sprintf("%dk", c.Bitrate), destPath}
+		codec = "libopus"
+		args = []string{"-i", tempPath, "-c:a", codec, "-b:a", fmt.Sprintf("%dk", c.Bitrate), destPath}
 	case "wav":
 		// uncompressed WAV
-		args = []string{"-i", tempPath, "-c:a", "pcm_s16le", destPath}
+		codec = "pcm_s16le"
+		args = []string{"-i", tempPath, "-c:a", codec, destPath}
 	default:
-		return "", fmt.Errorf("unsupported conversion format: %s", c.FormatToConvert)
+		return nil, fmt.Errorf("%w: unsupported conversion format: %s", ErrUnsupportedFormat, c.FormatToConvert)
 	}
 
 	// Execute ffmpeg
-	cmd := exec.Command("ffmpeg", args...)
 	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("error processing audio: %s, ffmpeg error: %s", err, stderr.String())
+	if err := runFFmpeg(ctx, c.Sandbox, demuxer, args, nil, nil, newProgressWriter(&stderr, c.Progress)); err != nil {
+		// Remove the partial output left by a failed or canceled run
+		_ = os.Remove(destPath)
+		return nil, fmt.Errorf("error processing audio: %w", err)
 	}
 
 	// Check if the file was created
 	if _, err := os.Stat(destPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("file was not created: %s", destPath)
+		return nil, fmt.Errorf("%w: file was not created: %s", ErrEncode, destPath)
+	}
+
+	result, err := newResult(destPath, c.FormatToConvert, codec, start)
+	if err != nil {
+		return nil, err
+	}
+	result.Duration = parseFFmpegDuration(stderr.String())
+	if c.Cache != nil {
+		c.Cache.putFile(cacheEntryKey, destPath, result)
+	}
+
+	// Store the processed audio
+	key, err := resultKey(c.Key, c.NameStrategy, "processed_", c.FileName, c.FormatToConvert, result)
+	if err != nil {
+		return nil, err
+	}
+	if err := storeResult(ctx, resolveStorage(c.Storage, c.DirToStorage), key, destPath, result); err != nil {
+		return nil, err
 	}
+	return result, nil
+}
 
-	return destPath, nil
+// cacheOptions returns the settings that change the output, the bitrate of uncompressed WAV is left out
+func (c *AudioConfig) cacheOptions() string {
+	bitrate := c.Bitrate
+	if c.FormatToConvert == WAV {
+		bitrate = 0
+	}
+	return fmt.Sprintf("audio format=%s bitrate=%d", c.FormatToConvert, bitrate)
 }
 
-func (c *AudioConfig) deleteAudio(reqFilePath ...string) error {
-	var filePath string
-	if len(reqFilePath) > 0 {
-		filePath = reqFilePath[0]
-	} else {
-		filePath = c.DirToStorage + c.FileName
+// storeCached puts a cached output into the storage
+func (c *AudioConfig) storeCached(ctx context.Context, cached io.Reader, result *Result, start time.Time) (*Result, error) {
+	key, err := resultKey(c.Key, c.NameStrategy, "processed_", c.FileName, c.FormatToConvert, result)
+	if err != nil {
+		return nil, err
+	}
+	if err := putResult(ctx, resolveStorage(c.Storage, c.DirToStorage), key, cached, result); err != nil {
+		return nil, err
 	}
+	result.Elapsed = time.Since(start)
+	return result, nil
+}
 
-	if err := os.Remove(filePath); err != nil {
+// storedKey returns the key Convert stores the audio under
+func (c *AudioConfig) storedKey() (string, error) {
+	return storedKey(c.Key, c.NameStrategy, "processed_", c.FileName, c.FormatToConvert)
+}
+
+func (c *AudioConfig) deleteAudio(reqFilePath ...string) error {
+	if err := deleteStored(c.Storage, c.DirToStorage, c.storedKey, reqFilePath); err != nil {
 		return fmt.Errorf("failed to delete audio: %w", err)
 	}
 	return nil
@@ -1,6 +1,9 @@
 package converter
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"path/filepath"
 	"strings"
 
@@ -12,7 +15,7 @@ const (
 	PNG  = "png"
 	JPEG = "jpeg"
 	JPG  = "jpg"
-	WEBP = "webp" // VP8X is not supported, only VP8 and VP8L are allowed
+	WEBP = "webp" // Simple (VP8), lossless (VP8L) and extended (VP8X) WebP
 	JFIF = "jfif"
 	// AVIF = "avif"
 	// HEIC = "heic"
@@ -46,6 +49,27 @@ const (
 	Json                    // 4
 )
 
+// String returns the lowercase name of the file type, for example "image"
+func (t FileType) String() string {
+	switch t {
+	case Image:
+		return "image"
+	case Video:
+		return "video"
+	case Audio:
+		return "audio"
+	case Json:
+		return "json"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the file type as its name, so it is readable in JSON
+func (t FileType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
 // DetermineFileType determines the type of a file based on its extension
 func DetermineFileType(fileName string) FileType {
 	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))
@@ -72,3 +96,187 @@ func contains(slice []string, item string) bool {
 	}
 	return false
 }
+
+// Formats that are only detected from the content, they are not supported as output
+const (
+	formatGIF  = "gif"
+	formatOGG  = "ogg"
+	formatOGV  = "ogv"
+	formatMKV  = "mkv"
+	formatMOV  = "mov"
+	formatM4V  = "m4v"
+	formatExe  = "exe"
+	formatELF  = "elf"
+	formatZIP  = "zip"
+	formatPDF  = "pdf"
+	formatNone = ""
+)
+
+// sniffLen is the number of leading bytes read to detect the content
+const sniffLen = 512
+
+// Detection is the file type detected from the content of a file
+type Detection struct {
+	FileType FileType // Type of the file, Unknown when the content is not recognized
+	Format   string   // Detected format, for example "png", "webm" or "opus"
+	MIMEType string   // MIME type of the detected format
+}
+
+// DetectFileType detects the file type from the leading bytes of a file.
+// At least 512 bytes should be passed when they are available.
+func DetectFileType(header []byte) Detection {
+	fileType, format := detectFormat(header)
+	return Detection{FileType: fileType, Format: format, MIMEType: MIMEType(format)}
+}
+
+// SniffFileType reads the leading bytes of file and detects its type.
+// The returned reader replays the sniffed bytes, it must be used instead of file.
+// ErrContentMismatch is returned when the extension of fileName disagrees with the content.
+func SniffFileType(fileName string, file io.Reader) (Detection, io.Reader, error) {
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return Detection{}, nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+	header = header[:n]
+	replay := io.MultiReader(bytes.NewReader(header), file)
+
+	detection := DetectFileType(header)
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))
+	if detection.FileType == Unknown || formatFamily(ext) != formatFamily(detection.Format) {
+		detected := detection.Format
+		if detected == formatNone {
+			detected = "unknown content"
+		}
+		return detection, replay, fmt.Errorf("%w: %s has extension %q but contains %s", ErrContentMismatch, fileName, ext, detected)
+	}
+	return detection, replay, nil
+}
+
+// detectFormat returns the file type and format of the leading bytes
+func detectFormat(b []byte) (FileType, string) {
+	switch {
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return Image, PNG
+	case bytes.HasPrefix(b, []byte{0xFF, 0xD8, 0xFF}):
+		return Image, JPEG
+	case bytes.HasPrefix(b, []byte("GIF87a")), bytes.HasPrefix(b, []byte("GIF89a")):
+		return Image, formatGIF
+	case len(b) >= 16 && string(b[0:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		// Simple (VP8), lossless (VP8L) and extended (VP8X) WebP
+		switch string(b[12:16]) {
+		case "VP8 ", "VP8L", "VP8X":
+			return Image, WEBP
+		}
+		return Unknown, formatNone
+	case len(b) >= 12 && string(b[0:4]) == "RIFF" && string(b[8:12]) == "WAVE":
+		return Audio, WAV
+	case len(b) >= 12 && string(b[4:8]) == "ftyp":
+		return detectISOBrand(string(b[8:12]))
+	case bytes.HasPrefix(b, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// EBML header, the DocType tells WebM from Matroska
+		if bytes.Contains(b, []byte("webm")) {
+			return Video, WEBM
+		}
+		return Video, formatMKV
+	case bytes.HasPrefix(b, []byte("OggS")):
+		switch {
+		case bytes.Contains(b, []byte("OpusHead")):
+			return Audio, OPUS
+		case bytes.Contains(b, []byte("\x80theora")):
+			return Video, formatOGV
+		}
+		return Audio, formatOGG
+	case bytes.HasPrefix(b, []byte("ID3")), isMP3Frame(b):
+		return Audio, MP3
+	case isJSON(b):
+		return Json, JSON
+	case bytes.HasPrefix(b, []byte("MZ")):
+		return Unknown, formatExe
+	case bytes.HasPrefix(b, []byte("\x7fELF")):
+		return Unknown, formatELF
+	case bytes.HasPrefix(b, []byte("PK\x03\x04")):
+		return Unknown, formatZIP
+	case bytes.HasPrefix(b, []byte("%PDF-")):
+		return Unknown, formatPDF
+	}
+	return Unknown, formatNone
+}
+
+// detectISOBrand returns the file type and format of an ISO base media file from its major brand
+func detectISOBrand(brand string) (FileType, string) {
+	switch {
+	case strings.HasPrefix(brand, "M4A"), strings.HasPrefix(brand, "M4B"):
+		return Audio, M4A
+	case strings.HasPrefix(brand, "M4V"):
+		return Video, formatM4V
+	case brand == "qt  ":
+		return Video, formatMOV
+	case brand == "avif", brand == "avis", brand == "heic", brand == "heix", brand == "mif1":
+		// AVIF and HEIC are not supported yet
+		return Unknown, formatNone
+	}
+	return Video, MP4
+}
+
+// isMP3Frame reports whether b starts with an MPEG audio layer III frame header
+func isMP3Frame(b []byte) bool {
+	if len(b) < 2 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return false
+	}
+	version := (b[1] >> 3) & 0x03
+	layer := (b[1] >> 1) & 0x03
+	return version != 0x01 && layer == 0x01
+}
+
+// isJSON reports whether b starts like a JSON object or array, after an optional BOM and whitespace
+func isJSON(b []byte) bool {
+	b = bytes.TrimPrefix(b, []byte("\xEF\xBB\xBF"))
+	b = bytes.TrimLeft(b, " \t\r\n")
+	return len(b) > 0 && (b[0] == '{' || b[0] == '[')
+}
+
+// formatFamily groups formats that share a container, so that for example a ".jpg" file
+// with JPEG content or a ".mp4" file with M4A content is not a mismatch
+func formatFamily(format string) string {
+	switch format {
+	case JPEG, JPG, JFIF:
+		return JPEG
+	case MP4, M4A, formatM4V, formatMOV:
+		return MP4
+	case WEBM, formatMKV:
+		return WEBM
+	case OPUS, formatOGG, formatOGV:
+		return formatOGG
+	}
+	return format
+}
+
+// demuxers are the ffmpeg demuxers of the sniffed video and audio formats
+var demuxers = map[string]string{
+	MP4:       "mov",
+	M4A:       "mov",
+	formatM4V: "mov",
+	formatMOV: "mov",
+	WEBM:      "matroska",
+	formatMKV: "matroska",
+	OPUS:      "ogg",
+	formatOGG: "ogg",
+	formatOGV: "ogg",
+	MP3:       "mp3",
+	WAV:       "wav",
+}
+
+// sniffMedia checks the content of a video or audio upload and returns the demuxer ffmpeg must use for it.
+// The returned reader replays the sniffed bytes, it must be used instead of file.
+func sniffMedia(fileName string, file io.Reader) (string, io.Reader, error) {
+	detection, file, err := SniffFileType(fileName, file)
+	if err != nil {
+		return "", nil, err
+	}
+	demuxer, ok := demuxers[detection.Format]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %s contains %s", ErrUnsupportedFormat, fileName, detection.Format)
+	}
+	return demuxer, file, nil
+}
@@ -1,14 +1,14 @@
 package converter
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/png"
 	"io"
 	"math"
-	"os"
-	"path/filepath"
-	"strings"
+	"time"
 
 	"github.com/disintegration/imaging"
 	_ "golang.org/x/image/webp"
@@ -16,17 +16,32 @@ import (
 
 // ImageConfig holds configuration for image processing
 type ImageConfig struct {
-	FileName              string    // Name of the file
-	File                  io.Reader // File reader for the image
-	Width                 int       // Target width for the image
-	Height                int       // Target height for the image
-	FormatToConvert       string    // Desired format to convert the image to
-	StretchThreshold      float64   // Threshold for stretching the image
-	Quality               int       // Quality of the output image
-	TransparentBackground bool      // Flag for transparent background
-	DirToStorage          string    // Directory to store the processed image
+	FileName              string       // Name of the file
+	File                  io.Reader    // File reader for the image
+	Width                 int          // Target width for the image
+	Height                int          // Target height for the image
+	FormatToConvert       string       // Desired format to convert the image to
+	StretchThreshold      float64      // Threshold for stretching the image
+	Quality               int          // Quality of the output image
+	TransparentBackground bool         // Flag for transparent background
+	DirToStorage          string       // Directory to store the processed image, used when Storage is nil
+	Storage               Storage      // Storage for the processed image
+	Key                   string       // Key of the processed image in the storage. Default: built with NameStrategy
+	NameStrategy          NameStrategy // Strategy for the default key. Default: NameOriginal, "processed_<name>.<format>"
+	Limits                Limits       // Limits for the source image. Zero fields use DefaultLimits
+	Fit                   Fit          // How the image is fitted into Width and Height. Default: FitContain
+	Cache                 *Cache       // Cache of converted outputs, nil disables caching
 }
 
+// Fit defines how an image is fitted into the target dimensions
+type Fit int
+
+const (
+	FitContain Fit = iota // The whole image on a blurred or transparent background, StretchThreshold applies
+	FitCover              // The target is covered and the overflow is cropped around the center
+	FitFill               // The image is stretched to the target dimensions
+)
+
 // Checks if the desired format is supported
 func (c *ImageConfig) isFormatSupported() bool {
 	for _, format := range supportedFormatsImage {
@@ -40,78 +55,146 @@ func (c *ImageConfig) isFormatSupported() bool {
 // Validates the configuration values
 func (c *ImageConfig) validateValues() error {
 	if c.Width <= 0 || c.Width > 8192 || c.Height <= 0 || c.Height > 8192 {
-		return fmt.Errorf("width and height must be greater than 0 and less than 8192")
+		return fmt.Errorf("%w: width and height must be greater than 0 and less than 8192", ErrInvalidDimensions)
 	}
 	if c.StretchThreshold < 0 || c.StretchThreshold > 100 {
-		return fmt.Errorf("stretch threshold must be between 0 and 100")
+		return fmt.Errorf("%w: stretch threshold must be between 0 and 100", ErrInvalidOption)
 	}
 	if c.Quality < 1 || c.Quality > 5 {
-		return fmt.Errorf("quality must be between 1 and 5")
+		return fmt.Errorf("%w: quality must be between 1 and 5", ErrInvalidOption)
 	}
-	if !c.isFormatSupported() {
-		return fmt.Errorf("unsupported format: %s", c.FormatToConvert)
+	if c.Fit < FitContain || c.Fit > FitFill {
+		return fmt.Errorf("%w: unknown fit %d", ErrInvalidOption, c.Fit)
 	}
-	if c.DirToStorage == "" {
-		return fmt.Errorf("dir to storage is required")
+	if !c.isFormatSupported() {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, c.FormatToConvert)
 	}
 	if c.FileName == "" {
-		return fmt.Errorf("file name is required")
+		return fmt.Errorf("%w: file name is required", ErrInvalidOption)
+	}
+	if err := validateFileName(c.FileName); err != nil {
+		return err
 	}
 	if c.File == nil {
-		return fmt.Errorf("file is required")
+		return fmt.Errorf("%w: file is required", ErrInvalidOption)
 	}
 	return nil
 }
 
-// ConvertImage converts the image to the desired format and saves it to the directory
-func (c *ImageConfig) convertImage() (string, error) {
-	if err := c.validateValues(); err != nil {
-		return "", err
+// ConvertImage converts the image to the desired format and puts it into the storage
+func (c *ImageConfig) convertImage(ctx context.Context) (*Result, error) {
+	if c.Storage == nil && c.DirToStorage == "" {
+		return nil, fmt.Errorf("%w: storage or dir to storage is required", ErrInvalidOption)
 	}
 
-	// Create the directory if it doesn't exist
-	if err := os.MkdirAll(c.DirToStorage, 0755); err != nil {
-		return "", fmt.Errorf("failed to create result dir: %w", err)
+	// Encode the processed image in memory
+	var buf bytes.Buffer
+	result, err := c.convertImageTo(ctx, &buf)
+	if err != nil {
+		return nil, err
 	}
 
-	// Save original image temporarily in dirResult
-	tempPath := filepath.Join(c.DirToStorage, c.FileName)
-	outFile, err := os.Create(tempPath)
+	// Store the processed image
+	key, err := resultKey(c.Key, c.NameStrategy, "processed_", c.FileName, c.FormatToConvert, result)
 	if err != nil {
-		return "", fmt.Errorf("failed to save original: %w", err)
+		return nil, err
+	}
+	if err := putResult(ctx, resolveStorage(c.Storage, c.DirToStorage), key, &buf, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// convertImageTo decodes the image from File, processes it in memory and writes the encoded output to w
+func (c *ImageConfig) convertImageTo(ctx context.Context, w io.Writer) (*Result, error) {
+	start := time.Now()
+	if err := c.validateValues(); err != nil {
+		return nil, err
+	}
+	if err := requireFormat(ctx, Image, c.FormatToConvert); err != nil {
+		return nil, err
+	}
+
+	render := func(input io.Reader, w io.Writer) (*Result, error) {
+		return c.renderImage(ctx, input, w, start)
+	}
+	if c.Cache != nil {
+		return c.Cache.convert(c.File, c.Limits, c.cacheOptions(), w, render)
+	}
+	return render(c.File, w)
+}
+
+// cacheOptions returns the settings that change the output, options that do not apply to the fit are left out
+func (c *ImageConfig) cacheOptions() string {
+	format := c.FormatToConvert
+	if format == JPEG {
+		format = JPG
+	}
+	stretchThreshold, transparent := c.StretchThreshold, c.TransparentBackground
+	if c.Fit != FitContain {
+		stretchThreshold, transparent = 0, false
 	}
-	if _, err := io.Copy(outFile, c.File); err != nil {
-		outFile.Close()
-		return "", fmt.Errorf("failed to write original: %w", err)
+	return fmt.Sprintf("image format=%s width=%d height=%d quality=%d stretch=%g transparent=%t fit=%d",
+		format, c.Width, c.Height, c.Quality, stretchThreshold, transparent, c.Fit)
+}
+
+// renderImage decodes the image from input, processes it and writes the encoded output to w
+func (c *ImageConfig) renderImage(ctx context.Context, input io.Reader, w io.Writer, start time.Time) (*Result, error) {
+	src, err := decodeImage(input, c.Limits)
+	if err != nil {
+		return nil, fmt.Errorf("error opening image: %w", err)
 	}
-	outFile.Close()
 
 	// Process image
-	processedPath, err := c.processImage(tempPath)
+	final, err := c.processImage(ctx, src)
 	if err != nil {
-		_ = os.Remove(tempPath)
-		return "", err
+		return nil, err
 	}
 
-	// Remove original
-	_ = os.Remove(tempPath)
+	// Determine quality based on configuration
+	quality := map[int]int{1: 30, 2: 50, 3: 70, 4: 85, 5: 95}[c.Quality]
+	if quality == 0 {
+		quality = 80
+	}
 
-	// Final file path
-	finalPath := filepath.Join(c.DirToStorage, filepath.Base(processedPath))
-	if err := os.Rename(processedPath, finalPath); err != nil {
-		return "", fmt.Errorf("failed to move processed image: %w", err)
+	out := newResultWriter(w)
+	if err := encodeImage(ctx, out, final, c.FormatToConvert, quality, pngCompressionLevel(c.Quality)); err != nil {
+		return nil, err
 	}
 
-	return finalPath, nil
+	result := out.result(c.FormatToConvert, imageCodec(c.FormatToConvert), start)
+	result.Width = c.Width
+	result.Height = c.Height
+	return result, nil
 }
 
-// Processes the image by resizing and converting it to the desired format
-func (c *ImageConfig) processImage(sourcePath string) (string, error) {
-	src, err := imaging.Open(sourcePath, imaging.AutoOrientation(true))
-	if err != nil {
-		return "", fmt.Errorf("error opening image: %w", err)
+// Processes the image by fitting it into the target dimensions
+func (c *ImageConfig) processImage(ctx context.Context, src image.Image) (*image.NRGBA, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx, err)
 	}
 
+	var final *image.NRGBA
+	switch c.Fit {
+	case FitCover:
+		final = imaging.Fill(src, c.Width, c.Height, imaging.Center, imaging.Lanczos)
+	case FitFill:
+		final = imaging.Resize(src, c.Width, c.Height, imaging.Lanczos)
+	default:
+		final = c.containImage(src)
+	}
+
+	// Apply sharpening and contrast adjustments
+	final = imaging.Sharpen(final, 0.5)
+	final = imaging.AdjustContrast(final, 2)
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return final, nil
+}
+
+// containImage fits the whole image into the target dimensions and centers it on the background
+func (c *ImageConfig) containImage(src image.Image) *image.NRGBA {
 	width := src.Bounds().Dx()
 	height := src.Bounds().Dy()
 	srcRatio := float64(width) / float64(height)
@@ -144,44 +227,28 @@ func (c *ImageConfig) processImage(sourcePath string) (string, error) {
 	// Center the resized image on the background
 	posX := (c.Width - resized.Bounds().Dx()) / 2
 	posY := (c.Height - resized.Bounds().Dy()) / 2
-	final := imaging.Overlay(background, resized, image.Pt(posX, posY), 1.0)
-
-	// Apply sharpening and contrast adjustments
-	final = imaging.Sharpen(final, 0.5)
-	final = imaging.AdjustContrast(final, 2)
-
-	base := strings.TrimSuffix(filepath.Base(sourcePath), filepath.Ext(sourcePath))
-	destPath := filepath.Join(c.DirToStorage, "processed_"+base+"."+c.FormatToConvert)
-
-	// Determine quality based on configuration
-	quality := map[int]int{1: 30, 2: 50, 3: 70, 4: 85, 5: 95}[c.Quality]
-	if quality == 0 {
-		quality = 80
-	}
+	return imaging.Overlay(background, resized, image.Pt(posX, posY), 1.0)
+}
 
-	// Save the final image in the desired format
-	switch c.FormatToConvert {
+// encodeImage writes img to w in the desired format
+func encodeImage(ctx context.Context, w io.Writer, img image.Image, format string, quality int, compression png.CompressionLevel) error {
+	var err error
+	switch format {
 	case PNG:
-		err = imaging.Save(final, destPath, imaging.PNGCompressionLevel(pngCompressionLevel(c.Quality)))
+		err = imaging.Encode(w, img, imaging.PNG, imaging.PNGCompressionLevel(compression))
 	case JPEG, JPG:
-		err = imaging.Save(final, destPath, imaging.JPEGQuality(quality))
+		err = imaging.Encode(w, img, imaging.JPEG, imaging.JPEGQuality(quality))
 	case WEBP:
-		webpData, err := convertToWebp(final, uint8(quality))
-		if err != nil {
-			return "", fmt.Errorf("webp encode error: %w", err)
-		}
-		err = os.WriteFile(destPath, webpData, 0644)
-		if err != nil {
-			return "", fmt.Errorf("error saving webp file: %w", err)
+		if err := encodeWebp(ctx, w, img, uint8(quality)); err != nil {
+			return fmt.Errorf("webp encode error: %w", err)
 		}
 	default:
-		return "", fmt.Errorf("unsupported format: %s", c.FormatToConvert)
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
 	}
 	if err != nil {
-		return "", fmt.Errorf("error saving processed file: %w", err)
+		return fmt.Errorf("%w: error encoding processed image: %w", ErrEncode, err)
 	}
-
-	return destPath, nil
+	return nil
 }
 
 // Creates a blurred background for the image
@@ -218,16 +285,14 @@ func pngCompressionLevel(quality int) png.CompressionLevel {
 	}
 }
 
+// storedKey returns the key Convert stores the image under
+func (c *ImageConfig) storedKey() (string, error) {
+	return storedKey(c.Key, c.NameStrategy, "processed_", c.FileName, c.FormatToConvert)
+}
+
 // deleteImage deletes the image from the directory
 func (c *ImageConfig) deleteImage(reqFilePath ...string) error {
-	var filePath string
-	if len(reqFilePath) > 0 {
-		filePath = reqFilePath[0]
-	} else {
-		filePath = c.DirToStorage + c.FileName
-	}
-
-	if err := os.Remove(filePath); err != nil {
+	if err := deleteStored(c.Storage, c.DirToStorage, c.storedKey, reqFilePath); err != nil {
 		return fmt.Errorf("failed to delete image: %w", err)
 	}
 	return nil
@@ -239,23 +304,55 @@ func (c *ImageConfig) deleteImage(reqFilePath ...string) error {
 
 // LogoConfig holds configuration for logo processing
 type LogoConfig struct {
-	FileName        string    // Name of the logo file
-	File            io.Reader // File reader for the logo
-	FormatToConvert string    // Format to convert the logo to
-	DirToStorage    string    // Directory to store the processed logo
-	MaxWidth        int       // Maximum width for the logo
-	MaxHeight       int       // Maximum height for the logo
-	MinWidth        int       // Minimum width for the logo
-	MinHeight       int       // Minimum height for the logo
+	FileName        string       // Name of the logo file
+	File            io.Reader    // File reader for the logo
+	FormatToConvert string       // Format to convert the logo to
+	DirToStorage    string       // Directory to store the processed logo, used when Storage is nil
+	Storage         Storage      // Storage for the processed logo
+	Key             string       // Key of the processed logo in the storage. Default: built with NameStrategy
+	NameStrategy    NameStrategy // Strategy for the default key. Default: NameOriginal, "<name>.<format>"
+	Limits          Limits       // Limits for the source logo. Zero fields use DefaultLimits
+	MaxWidth        int          // Maximum width for the logo
+	MaxHeight       int          // Maximum height for the logo
+	MinWidth        int          // Minimum width for the logo
+	MinHeight       int          // Minimum height for the logo
+	Cache           *Cache       // Cache of converted outputs, nil disables caching
 }
 
 // ProcessLogo handles logo upload, resizing with quality strategies, and saves it in the specified format.
-func (cfg *LogoConfig) processLogo() (string, error) {
-	if cfg.File == nil {
-		return "", fmt.Errorf("logo file is required")
+func (cfg *LogoConfig) processLogo(ctx context.Context) (*Result, error) {
+	if cfg.Storage == nil && cfg.DirToStorage == "" {
+		return nil, fmt.Errorf("%w: Storage or DirToStorage is required", ErrInvalidOption)
 	}
-	if cfg.DirToStorage == "" {
-		return "", fmt.Errorf("DirToStorage is required")
+	if cfg.Key == "" && cfg.NameStrategy == NameOriginal {
+		if err := validateFileName(cfg.FileName); err != nil {
+			return nil, err
+		}
+	}
+
+	// Encode the processed logo in memory
+	var buf bytes.Buffer
+	result, err := cfg.processLogoTo(ctx, &buf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the processed logo
+	key, err := resultKey(cfg.Key, cfg.NameStrategy, "", cfg.FileName, cfg.FormatToConvert, result)
+	if err != nil {
+		return nil, err
+	}
+	if err := putResult(ctx, resolveStorage(cfg.Storage, cfg.DirToStorage), key, &buf, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// processLogoTo decodes the logo from File, resizes it in memory and writes the encoded output to w
+func (cfg *LogoConfig) processLogoTo(ctx context.Context, w io.Writer) (*Result, error) {
+	start := time.Now()
+	if cfg.File == nil {
+		return nil, fmt.Errorf("%w: logo file is required", ErrInvalidOption)
 	}
 
 	// Set default format to webp if not specified
@@ -273,28 +370,35 @@ func (cfg *LogoConfig) processLogo() (string, error) {
 		}
 	}
 	if !isSupported {
-		return "", fmt.Errorf("unsupported format: %s", cfg.FormatToConvert)
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, cfg.FormatToConvert)
 	}
-
-	if err := os.MkdirAll(cfg.DirToStorage, 0755); err != nil {
-		return "", err
+	if err := requireFormat(ctx, Image, cfg.FormatToConvert); err != nil {
+		return nil, err
 	}
 
-	tempPath := filepath.Join(cfg.DirToStorage, "original_"+cfg.FileName)
-	outFile, err := os.Create(tempPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create logo temp file: %w", err)
+	render := func(input io.Reader, w io.Writer) (*Result, error) {
+		return cfg.renderLogo(ctx, input, w, start)
 	}
-	if _, err := io.Copy(outFile, cfg.File); err != nil {
-		outFile.Close()
-		return "", fmt.Errorf("failed to write logo file: %w", err)
+	if cfg.Cache != nil {
+		return cfg.Cache.convert(cfg.File, cfg.Limits, cfg.cacheOptions(), w, render)
 	}
-	outFile.Close()
+	return render(cfg.File, w)
+}
 
-	src, err := imaging.Open(tempPath, imaging.AutoOrientation(true))
+// cacheOptions returns the settings that change the output
+func (cfg *LogoConfig) cacheOptions() string {
+	return fmt.Sprintf("logo format=%s max=%dx%d min=%dx%d",
+		cfg.FormatToConvert, cfg.MaxWidth, cfg.MaxHeight, cfg.MinWidth, cfg.MinHeight)
+}
+
+// renderLogo decodes the logo from input, resizes it and writes the encoded output to w
+func (cfg *LogoConfig) renderLogo(ctx context.Context, input io.Reader, w io.Writer, start time.Time) (*Result, error) {
+	src, err := decodeImage(input, cfg.Limits)
 	if err != nil {
-		os.Remove(tempPath)
-		return "", fmt.Errorf("error opening uploaded logo: %w", err)
+		return nil, fmt.Errorf("error opening uploaded logo: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx, err)
 	}
 
 	width := src.Bounds().Dx()
@@ -319,35 +423,16 @@ func (cfg *LogoConfig) processLogo() (string, error) {
 	resized = imaging.AdjustContrast(resized, 2)
 	resized = imaging.AdjustBrightness(resized, 2)
 
-	outputName := strings.TrimSuffix(cfg.FileName, filepath.Ext(cfg.FileName)) + "." + cfg.FormatToConvert
-	outputPath := filepath.Join(cfg.DirToStorage, outputName)
-
-	// Convert and save the logo in the specified format
-	switch cfg.FormatToConvert {
-	case "png":
-		err = imaging.Save(resized, outputPath, imaging.PNGCompressionLevel(png.BestCompression))
-	case "jpg":
-		err = imaging.Save(resized, outputPath, imaging.JPEGQuality(95))
-	case "webp":
-		webpData, err := convertToWebp(resized, 95)
-		if err != nil {
-			os.Remove(tempPath)
-			return "", err
-		}
-		err = os.WriteFile(outputPath, webpData, 0644)
-		if err != nil {
-			return "", fmt.Errorf("error saving webp file: %w", err)
-		}
-	default:
-		return "", fmt.Errorf("unsupported format: %s", cfg.FormatToConvert)
-	}
-	if err != nil {
-		os.Remove(tempPath)
-		return "", fmt.Errorf("error saving processed file: %w", err)
+	// Convert the logo to the specified format
+	out := newResultWriter(w)
+	if err := encodeImage(ctx, out, resized, cfg.FormatToConvert, 95, png.BestCompression); err != nil {
+		return nil, err
 	}
 
-	os.Remove(tempPath)
-	return outputPath, nil
+	result := out.result(cfg.FormatToConvert, imageCodec(cfg.FormatToConvert), start)
+	result.Width = resized.Bounds().Dx()
+	result.Height = resized.Bounds().Dy()
+	return result, nil
 }
 
 // calculateDimensions resizes keeping proportions, with max/min thresholds
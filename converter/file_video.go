@@ -1,11 +1,14 @@
 package converter
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 )
@@ -19,6 +22,12 @@ type VideoConfig struct {
 	Quality               int
 	TransparentBackground bool
 	DirToStorage          string
+	Storage               Storage       // used instead of DirToStorage when set
+	Key                   string        // key in the storage, default built with NameStrategy
+	NameStrategy          NameStrategy  // default NameOriginal, "processed_<name>.<format>"
+	Sandbox               Sandbox       // limits of the ffmpeg run, zero fields use DefaultSandbox
+	Progress              func(float64) // called with the converted fraction 0-1 while ffmpeg runs
+	Cache                 *Cache        // cache of converted outputs, nil disables caching
 }
 
 func (c *VideoConfig) isFormatSupported() bool {
@@ -32,25 +41,28 @@ func (c *VideoConfig) isFormatSupported() bool {
 
 func (c *VideoConfig) validateValues() error {
 	if c.Width <= 0 || c.Height <= 0 {
-		return fmt.Errorf("width and height must be greater than 0")
+		return fmt.Errorf("%w: width and height must be greater than 0", ErrInvalidDimensions)
 	}
 	if c.Quality < 1 || c.Quality > 5 {
-		return fmt.Errorf("quality must be between 1 and 5")
+		return fmt.Errorf("%w: quality must be between 1 and 5", ErrInvalidOption)
 	}
 	if !c.isFormatSupported() {
-		return fmt.Errorf("unsupported format: %s", c.FormatToConvert)
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, c.FormatToConvert)
 	}
-	if c.DirToStorage == "" {
-		return fmt.Errorf("dir to storage is required")
+	if c.Storage == nil && c.DirToStorage == "" {
+		return fmt.Errorf("%w: storage or dir to storage is required", ErrInvalidOption)
 	}
 	if c.FileName == "" {
-		return fmt.Errorf("file name is required")
+		return fmt.Errorf("%w: file name is required", ErrInvalidOption)
+	}
+	if err := validateFileName(c.FileName); err != nil {
+		return err
 	}
 	if c.File == nil {
-		return fmt.Errorf("file is required")
+		return fmt.Errorf("%w: file is required", ErrInvalidOption)
 	}
 	if !c.isFileExtensionSupported() {
-		return fmt.Errorf("unsupported file extension: %s", filepath.Ext(c.FileName))
+		return fmt.Errorf("%w: unsupported file extension: %s", ErrUnsupportedFormat, filepath.Ext(c.FileName))
 	}
 	return nil
 }
@@ -60,31 +72,49 @@ func (c *VideoConfig) isFileExtensionSupported() bool {
 	return ext == ".mp4" || ext == ".webm"
 }
 
-func (c *VideoConfig) processVideo() (string, error) {
+func (c *VideoConfig) processVideo(ctx context.Context) (*Result, error) {
+	start := time.Now()
 	if err := c.validateValues(); err != nil {
-		return "", err
+		return nil, err
 	}
-
-	if err := os.MkdirAll(c.DirToStorage, 0755); err != nil {
-		return "", fmt.Errorf("failed to create result dir: %w", err)
+	if err := requireFormat(ctx, Video, c.FormatToConvert); err != nil {
+		return nil, err
 	}
 
-	// Save original video temporarily in dirResult
-	tempPath := filepath.Join(c.DirToStorage, c.FileName)
-	outFile, err := os.Create(tempPath)
+	// Save original video in a temporary directory
+	// The demuxer of the sniffed format is forced, ffmpeg never probes the upload
+	demuxer, file, err := sniffMedia(c.FileName, c.File)
+	if err != nil {
+		return nil, err
+	}
+	workDir, tempPath, err := stageOriginal(file, c.FileName)
 	if err != nil {
-		return "", fmt.Errorf("failed to save original: %w", err)
+		return nil, err
 	}
-	defer os.Remove(tempPath) // Ensure the file is removed after processing
-	if _, err := io.Copy(outFile, c.File); err != nil {
-		outFile.Close()
-		return "", fmt.Errorf("failed to write original: %w", err)
+	defer os.RemoveAll(workDir)
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	// Concurrent conversions of the same input and settings wait for the first one
+	var cacheEntryKey string
+	if c.Cache != nil {
+		hash, err := fileHash(tempPath)
+		if err != nil {
+			return nil, err
+		}
+		cacheEntryKey = cacheKey(hash, c.cacheOptions())
+		unlock := c.Cache.lock(cacheEntryKey)
+		defer unlock()
+		if cached, result, ok := c.Cache.get(cacheEntryKey); ok {
+			defer cached.Close()
+			return c.storeCached(ctx, cached, result, start)
+		}
 	}
-	outFile.Close()
 
 	// Create a path for the processed file
 	filename := strings.TrimSuffix(filepath.Base(tempPath), filepath.Ext(tempPath))
-	destPath := filepath.Join(c.DirToStorage, "processed_"+filename+"."+c.FormatToConvert)
+	destPath := filepath.Join(workDir, "processed_"+filename+"."+c.FormatToConvert)
 
 	// Define quality settings
 	var crf int
@@ -113,21 +143,25 @@ func (c *VideoConfig) processVideo() (string, error) {
 		preset = "fast"
 		maxrate = "3M"
 	default:
-		return "", fmt.Errorf("unsupported quality setting: %d", c.Quality)
+		return nil, fmt.Errorf("%w: unsupported quality setting: %d", ErrInvalidOption, c.Quality)
 	}
 
 	// Check if the format is webm and use the convertToWebm function
+	var stderr bytes.Buffer
+	var codec string
 	if c.FormatToConvert == "webm" {
-		webmData, err := convertToWebm(tempPath, crf, c.Width, c.Height)
+		codec = "libvpx"
+		webmData, err := convertToWebm(ctx, c.Sandbox, demuxer, tempPath, crf, c.Width, c.Height, newProgressWriter(&stderr, c.Progress))
 		if err != nil {
-			return "", fmt.Errorf("error converting to webm: %w", err)
+			return nil, fmt.Errorf("error converting to webm: %w", err)
 		}
 		if err := os.WriteFile(destPath, webmData, 0644); err != nil {
-			return "", fmt.Errorf("error writing webm file: %w", err)
+			return nil, fmt.Errorf("error writing webm file: %w", err)
 		}
 	} else {
 		// Use ffmpeg to resize, crop, and convert the video
-		err = ffmpeg.Input(tempPath).
+		codec = "libx264"
+		stream := ffmpeg.Input(tempPath).
 			Filter("scale", ffmpeg.Args{fmt.Sprintf("iw*min(%d/iw\\,%d/ih):ih*min(%d/iw\\,%d/ih)", c.Width, c.Height, c.Width, c.Height)}).
 			Filter("pad", ffmpeg.Args{fmt.Sprintf("%d:%d:(%d-iw)/2:(%d-ih)/2", c.Width, c.Height, c.Width, c.Height)}).
 			Output(destPath, ffmpeg.KwArgs{
@@ -138,27 +172,63 @@ func (c *VideoConfig) processVideo() (string, error) {
 				"bufsize": "2M",
 				"b:v":     "1M",
 			}).
-			OverWriteOutput().
-			Run()
-
-		if err != nil {
-			return "", fmt.Errorf("error processing video: %w", err)
+			OverWriteOutput()
+		if err := runFFmpeg(ctx, c.Sandbox, demuxer, stream.GetArgs(), nil, nil, newProgressWriter(&stderr, c.Progress)); err != nil {
+			// Remove the partial output left by a failed or canceled run
+			_ = os.Remove(destPath)
+			return nil, fmt.Errorf("error processing video: %w", err)
 		}
 	}
 
-	return destPath, nil
+	result, err := newResult(destPath, c.FormatToConvert, codec, start)
+	if err != nil {
+		return nil, err
+	}
+	result.Width = c.Width
+	result.Height = c.Height
+	result.Duration = parseFFmpegDuration(stderr.String())
+	if c.Cache != nil {
+		c.Cache.putFile(cacheEntryKey, destPath, result)
+	}
+
+	// Store the processed video
+	key, err := resultKey(c.Key, c.NameStrategy, "processed_", c.FileName, c.FormatToConvert, result)
+	if err != nil {
+		return nil, err
+	}
+	if err := storeResult(ctx, resolveStorage(c.Storage, c.DirToStorage), key, destPath, result); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-// deleteVideo deletes the video from the directory
-func (c *VideoConfig) deleteVideo(reqFilePath ...string) error {
-	var filePath string
-	if len(reqFilePath) > 0 {
-		filePath = reqFilePath[0]
-	} else {
-		filePath = c.DirToStorage + c.FileName
+// cacheOptions returns the settings that change the output
+func (c *VideoConfig) cacheOptions() string {
+	return fmt.Sprintf("video format=%s width=%d height=%d quality=%d",
+		c.FormatToConvert, c.Width, c.Height, c.Quality)
+}
+
+// storeCached puts a cached output into the storage
+func (c *VideoConfig) storeCached(ctx context.Context, cached io.Reader, result *Result, start time.Time) (*Result, error) {
+	key, err := resultKey(c.Key, c.NameStrategy, "processed_", c.FileName, c.FormatToConvert, result)
+	if err != nil {
+		return nil, err
+	}
+	if err := putResult(ctx, resolveStorage(c.Storage, c.DirToStorage), key, cached, result); err != nil {
+		return nil, err
 	}
+	result.Elapsed = time.Since(start)
+	return result, nil
+}
 
-	if err := os.Remove(filePath); err != nil {
+// storedKey returns the key Convert stores the video under
+func (c *VideoConfig) storedKey() (string, error) {
+	return storedKey(c.Key, c.NameStrategy, "processed_", c.FileName, c.FormatToConvert)
+}
+
+// deleteVideo deletes the video from the directory
+func (c *VideoConfig) deleteVideo(reqFilePath ...string) error {
+	if err := deleteStored(c.Storage, c.DirToStorage, c.storedKey, reqFilePath); err != nil {
 		return fmt.Errorf("failed to delete video: %w", err)
 	}
 	return nil
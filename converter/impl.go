@@ -1,12 +1,37 @@
 package converter
 
+import (
+	"context"
+	"io"
+)
+
 // Convert() converts the image to the desired format and saves it to the directory
+// It returns a Result with the path, MIME type, size, dimensions and checksum of the output.
 // This method is a public interface for converting images using the configuration
 // specified in the ImageConfig struct. It calls the private method convertImage
 // which handles the actual conversion process.
-func (c *ImageConfig) Convert() (string, error) {
+func (c *ImageConfig) Convert() (*Result, error) {
+	return c.ConvertContext(context.Background())
+}
+
+// ConvertContext() is Convert with a context
+// The conversion stops with ErrCanceled when the context is canceled or its
+// deadline is exceeded; the cwebp subprocess is killed and temporary files are removed.
+func (c *ImageConfig) ConvertContext(ctx context.Context) (*Result, error) {
 	// Calls the internal convertImage method to perform the conversion
-	return c.convertImage()
+	return c.convertImage(ctx)
+}
+
+// ConvertTo() converts the image and writes it to w instead of the storage
+// The image is decoded from File, processed and encoded in memory, so no
+// temporary files are created. The Path and Key of the Result are empty.
+func (c *ImageConfig) ConvertTo(w io.Writer) (*Result, error) {
+	return c.ConvertToContext(context.Background(), w)
+}
+
+// ConvertToContext() is ConvertTo with a context
+func (c *ImageConfig) ConvertToContext(ctx context.Context, w io.Writer) (*Result, error) {
+	return c.convertImageTo(ctx, w)
 }
 
 // Delete() deletes the image from the directory
@@ -23,17 +48,43 @@ func (c *ImageConfig) Delete(reqFilePath ...string) error {
 // This method is a public interface for processing logos using the configuration
 // specified in the LogoConfig struct. It calls the private method processLogo
 // which handles the actual processing and conversion to WebP format.
-func (c *LogoConfig) Convert() (string, error) {
+func (c *LogoConfig) Convert() (*Result, error) {
+	return c.ConvertContext(context.Background())
+}
+
+// ConvertContext() is Convert with a context
+// The processing stops with ErrCanceled when the context is canceled or its
+// deadline is exceeded; the cwebp subprocess is killed and temporary files are removed.
+func (c *LogoConfig) ConvertContext(ctx context.Context) (*Result, error) {
 	// Calls the internal processLogo method to perform the logo processing
-	return c.processLogo()
+	return c.processLogo(ctx)
+}
+
+// ConvertTo() processes the logo and writes it to w instead of the storage
+// The logo is decoded from File, resized and encoded in memory, so no
+// temporary files are created. The Path and Key of the Result are empty.
+func (c *LogoConfig) ConvertTo(w io.Writer) (*Result, error) {
+	return c.ConvertToContext(context.Background(), w)
+}
+
+// ConvertToContext() is ConvertTo with a context
+func (c *LogoConfig) ConvertToContext(ctx context.Context, w io.Writer) (*Result, error) {
+	return c.processLogoTo(ctx, w)
 }
 
 // Convert() is a public method to convert video using the VideoConfig settings
 // This method is a public interface for converting videos using the configuration
 // specified in the VideoConfig struct. It calls the private method processVideo
 // which handles the actual conversion process.
-func (c *VideoConfig) Convert() (string, error) {
-	return c.processVideo()
+func (c *VideoConfig) Convert() (*Result, error) {
+	return c.ConvertContext(context.Background())
+}
+
+// ConvertContext() is Convert with a context
+// The conversion stops with ErrCanceled when the context is canceled or its
+// deadline is exceeded; the ffmpeg process tree is killed and temporary files are removed.
+func (c *VideoConfig) ConvertContext(ctx context.Context) (*Result, error) {
+	return c.processVideo(ctx)
 }
 
 // Delete() is a public method to delete video from the directory
@@ -49,8 +100,15 @@ func (c *VideoConfig) Delete(reqFilePath ...string) error {
 // This method is a public interface for converting audio using the configuration
 // specified in the AudioConfig struct. It calls the private method processAudio
 // which handles the actual conversion process.
-func (c *AudioConfig) Convert() (string, error) {
-	return c.processAudio()
+func (c *AudioConfig) Convert() (*Result, error) {
+	return c.ConvertContext(context.Background())
+}
+
+// ConvertContext() is Convert with a context
+// The conversion stops with ErrCanceled when the context is canceled or its
+// deadline is exceeded; the ffmpeg process tree is killed and temporary files are removed.
+func (c *AudioConfig) ConvertContext(ctx context.Context) (*Result, error) {
+	return c.processAudio(ctx)
 }
 
 // Delete() is a public method to delete audio from the directory
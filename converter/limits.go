@@ -0,0 +1,141 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// Limits bounds the resources used to decode an input image.
+// A zero field uses the value of DefaultLimits, a negative field disables the limit.
+type Limits struct {
+	MaxInputBytes int64 // Maximum size of the uploaded file in bytes
+	MaxPixels     int64 // Maximum width*height of the source image
+	MaxWidth      int   // Maximum width of the source image
+	MaxHeight     int   // Maximum height of the source image
+}
+
+// DefaultLimits are the limits used when a field of Limits is zero.
+// 64 megapixels decode to 256 MB of RGBA data.
+var DefaultLimits = Limits{
+	MaxInputBytes: 50 << 20,
+	MaxPixels:     64_000_000,
+	MaxWidth:      16384,
+	MaxHeight:     16384,
+}
+
+// LimitError is returned when the input exceeds one of the Limits.
+// It wraps ErrLimitExceeded.
+type LimitError struct {
+	Limit string // Name of the exceeded limit, for example "MaxPixels"
+	Value int64  // Value of the input, for the byte size it is the first value over the limit
+	Max   int64  // Configured limit
+}
+
+// Error returns the error message
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %s is %d, the limit is %d", ErrLimitExceeded, e.Limit, e.Value, e.Max)
+}
+
+// Unwrap returns ErrLimitExceeded
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// withDefaults returns the limits with zero fields replaced by DefaultLimits
+func (l Limits) withDefaults() Limits {
+	if l.MaxInputBytes == 0 {
+		l.MaxInputBytes = DefaultLimits.MaxInputBytes
+	}
+	if l.MaxPixels == 0 {
+		l.MaxPixels = DefaultLimits.MaxPixels
+	}
+	if l.MaxWidth == 0 {
+		l.MaxWidth = DefaultLimits.MaxWidth
+	}
+	if l.MaxHeight == 0 {
+		l.MaxHeight = DefaultLimits.MaxHeight
+	}
+	return l
+}
+
+// checkConfig checks the dimensions of the source image
+func (l Limits) checkConfig(config image.Config) error {
+	if l.MaxWidth > 0 && config.Width > l.MaxWidth {
+		return &LimitError{Limit: "MaxWidth", Value: int64(config.Width), Max: int64(l.MaxWidth)}
+	}
+	if l.MaxHeight > 0 && config.Height > l.MaxHeight {
+		return &LimitError{Limit: "MaxHeight", Value: int64(config.Height), Max: int64(l.MaxHeight)}
+	}
+	if pixels := int64(config.Width) * int64(config.Height); l.MaxPixels > 0 && pixels > l.MaxPixels {
+		return &LimitError{Limit: "MaxPixels", Value: pixels, Max: l.MaxPixels}
+	}
+	return nil
+}
+
+// limitedReader returns a LimitError once more than max bytes are read
+type limitedReader struct {
+	r    io.Reader
+	read int64
+	max  int64
+}
+
+// Read reads from the underlying reader and counts the bytes
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if err := lr.err(); err != nil {
+		return 0, err
+	}
+	if lr.max > 0 && int64(len(p)) > lr.max-lr.read+1 {
+		p = p[:lr.max-lr.read+1]
+	}
+	n, err := lr.r.Read(p)
+	lr.read += int64(n)
+	if limitErr := lr.err(); limitErr != nil {
+		return n, limitErr
+	}
+	return n, err
+}
+
+// err returns a LimitError when more than max bytes were read
+func (lr *limitedReader) err() error {
+	if lr.max > 0 && lr.read > lr.max {
+		return &LimitError{Limit: "MaxInputBytes", Value: lr.read, Max: lr.max}
+	}
+	return nil
+}
+
+// decodeImage decodes an image with auto orientation after checking the limits.
+// The dimensions are read with image.DecodeConfig, so oversized images are rejected
+// before their pixels are allocated.
+func decodeImage(r io.Reader, limits Limits) (image.Image, error) {
+	limits = limits.withDefaults()
+	input := &limitedReader{r: r, max: limits.MaxInputBytes}
+
+	// Keep the header read by DecodeConfig to replay it for the full decode
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(input, &header))
+	if err != nil {
+		return nil, decodeError(input, err)
+	}
+	if err := limits.checkConfig(config); err != nil {
+		return nil, err
+	}
+
+	img, err := imaging.Decode(io.MultiReader(&header, input), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, decodeError(input, err)
+	}
+	return img, nil
+}
+
+// decodeError returns a LimitError when the input was too large, otherwise err wrapped with ErrDecode.
+// Decoders do not always return the error of the reader, so the reader is checked directly.
+func decodeError(input *limitedReader, err error) error {
+	if limitErr := input.err(); limitErr != nil {
+		return limitErr
+	}
+	return fmt.Errorf("%w: %w", ErrDecode, err)
+}
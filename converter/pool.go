@@ -0,0 +1,464 @@
+package converter
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Errors returned by Pool
+var (
+	ErrQueueFull  = errors.New("conversion queue is full")  // TrySubmit found no room in the queue
+	ErrPoolClosed = errors.New("conversion pool is closed") // the pool does not accept jobs anymore
+)
+
+// Priority of a job in the queue, higher priorities run first
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// JobStatus is the state of a job
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// PoolConfig holds configuration for a Pool
+type PoolConfig struct {
+	Workers      map[FileType]int // Concurrent conversions per media type. Default: Image=NumCPU, Video=1, Audio=2
+	QueueSize    int              // Maximum number of queued jobs of all types. Default: 100
+	JobRetention time.Duration    // How long finished jobs are kept for Job lookups. Default: 10 minutes
+}
+
+// Job is a conversion submitted to a Pool
+type Job struct {
+	ID       string   // Unique id of the job
+	Type     FileType // Media type, it selects the worker group
+	Priority Priority // Priority in the queue
+
+	ctx       context.Context
+	converter Converter
+	seq       uint64 // submission order, FIFO within a priority
+	index     int    // index in the queue heap
+
+	mu         sync.Mutex
+	status     JobStatus
+	result     *Result
+	err        error
+	queuedAt   time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+	done       chan struct{}
+}
+
+// JobInfo is a snapshot of a job
+type JobInfo struct {
+	ID         string        `json:"id"`
+	Type       FileType      `json:"type"`
+	Priority   Priority      `json:"priority"`
+	Status     JobStatus     `json:"status"`
+	Error      string        `json:"error,omitempty"`
+	QueuedAt   time.Time     `json:"queuedAt"`
+	StartedAt  time.Time     `json:"startedAt,omitempty"`
+	FinishedAt time.Time     `json:"finishedAt,omitempty"`
+	Wait       time.Duration `json:"wait"` // Time spent in the queue
+}
+
+// Status returns the current status of the job
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Info returns a snapshot of the job
+func (j *Job) Info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := JobInfo{
+		ID:         j.ID,
+		Type:       j.Type,
+		Priority:   j.Priority,
+		Status:     j.status,
+		QueuedAt:   j.queuedAt,
+		StartedAt:  j.startedAt,
+		FinishedAt: j.finishedAt,
+	}
+	if j.err != nil {
+		info.Error = j.err.Error()
+	}
+	switch {
+	case !j.startedAt.IsZero():
+		info.Wait = j.startedAt.Sub(j.queuedAt)
+	case !j.finishedAt.IsZero():
+		info.Wait = j.finishedAt.Sub(j.queuedAt)
+	default:
+		info.Wait = time.Since(j.queuedAt)
+	}
+	return info
+}
+
+// Done returns a channel that is closed when the job is done or failed
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Wait waits for the job and returns its result. The job keeps running when ctx is done.
+func (j *Job) Wait(ctx context.Context) (*Result, error) {
+	select {
+	case <-j.done:
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		return j.result, j.err
+	case <-ctx.Done():
+		return nil, contextError(ctx, ctx.Err())
+	}
+}
+
+// finish stores the outcome of the job
+func (j *Job) finish(result *Result, err error) {
+	j.mu.Lock()
+	j.result = result
+	j.err = err
+	j.status = JobDone
+	if err != nil {
+		j.status = JobFailed
+	}
+	j.finishedAt = time.Now()
+	j.mu.Unlock()
+	close(j.done)
+}
+
+// jobQueue is a priority queue of jobs, FIFO within a priority
+type jobQueue []*Job
+
+func (q jobQueue) Len() int { return len(q) }
+func (q jobQueue) Less(i, k int) bool {
+	if q[i].Priority != q[k].Priority {
+		return q[i].Priority > q[k].Priority
+	}
+	return q[i].seq < q[k].seq
+}
+func (q jobQueue) Swap(i, k int) {
+	q[i], q[k] = q[k], q[i]
+	q[i].index = i
+	q[k].index = k
+}
+func (q *jobQueue) Push(x any) {
+	job := x.(*Job)
+	job.index = len(*q)
+	*q = append(*q, job)
+}
+func (q *jobQueue) Pop() any {
+	old := *q
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return job
+}
+
+// TypeStats are the statistics of a worker group
+type TypeStats struct {
+	Workers int           `json:"workers"` // Concurrency limit
+	Queued  int           `json:"queued"`  // Jobs waiting in the queue
+	Running int           `json:"running"` // Jobs being converted
+	Done    uint64        `json:"done"`    // Jobs finished successfully
+	Failed  uint64        `json:"failed"`  // Jobs finished with an error
+	AvgWait time.Duration `json:"avgWait"` // Average time started jobs spent in the queue
+	MaxWait time.Duration `json:"maxWait"` // Longest time a started job spent in the queue
+}
+
+// PoolStats are the statistics of a Pool
+type PoolStats struct {
+	QueueDepth int                    `json:"queueDepth"` // Jobs waiting in the queue, all types
+	QueueSize  int                    `json:"queueSize"`  // Maximum queue depth
+	Types      map[FileType]TypeStats `json:"types"`      // Statistics per media type
+}
+
+// workerGroup runs the jobs of one media type
+type workerGroup struct {
+	workers   int
+	work      *sync.Cond // signaled when a job of the group is queued, broadcast when the pool is closed
+	queue     jobQueue
+	running   int
+	done      uint64
+	failed    uint64
+	started   uint64
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+// Pool runs conversions with a concurrency limit per media type and a bounded priority queue
+type Pool struct {
+	config PoolConfig
+
+	mu     sync.Mutex
+	space  *sync.Cond // signaled when a job leaves the queue
+	groups map[FileType]*workerGroup
+	jobs   map[string]*Job
+	queued int
+	seq    uint64
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewPool creates a pool and starts its workers
+func NewPool(config PoolConfig) *Pool {
+	workers := map[FileType]int{Image: runtime.NumCPU(), Video: 1, Audio: 2}
+	for fileType, n := range config.Workers {
+		workers[fileType] = n
+	}
+	config.Workers = workers
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100
+	}
+	if config.JobRetention <= 0 {
+		config.JobRetention = 10 * time.Minute
+	}
+
+	p := &Pool{
+		config: config,
+		groups: make(map[FileType]*workerGroup),
+		jobs:   make(map[string]*Job),
+	}
+	p.space = sync.NewCond(&p.mu)
+	for fileType, n := range config.Workers {
+		if n <= 0 {
+			continue
+		}
+		group := &workerGroup{workers: n, work: sync.NewCond(&p.mu)}
+		p.groups[fileType] = group
+		for i := 0; i < n; i++ {
+			p.wg.Add(1)
+			go p.worker(group)
+		}
+	}
+	return p
+}
+
+// converterType returns the media type of a converter
+func converterType(converter Converter) (FileType, error) {
+	switch converter.(type) {
+	case *ImageConfig, *LogoConfig:
+		return Image, nil
+	case *VideoConfig:
+		return Video, nil
+	case *AudioConfig:
+		return Audio, nil
+	}
+	return Unknown, fmt.Errorf("%w: unknown media type of %T, use SubmitType", ErrInvalidOption, converter)
+}
+
+// Submit queues a conversion, the media type is taken from the config.
+// It blocks while the queue is full, until there is room or ctx is done.
+// ctx is also used by the conversion.
+func (p *Pool) Submit(ctx context.Context, converter Converter, priority Priority) (*Job, error) {
+	fileType, err := converterType(converter)
+	if err != nil {
+		return nil, err
+	}
+	return p.SubmitType(ctx, fileType, converter, priority)
+}
+
+// SubmitType is Submit with an explicit media type, for custom converters
+func (p *Pool) SubmitType(ctx context.Context, fileType FileType, converter Converter, priority Priority) (*Job, error) {
+	return p.submit(ctx, fileType, converter, priority, true)
+}
+
+// TrySubmit is Submit that returns ErrQueueFull instead of blocking when the queue is full
+func (p *Pool) TrySubmit(ctx context.Context, converter Converter, priority Priority) (*Job, error) {
+	fileType, err := converterType(converter)
+	if err != nil {
+		return nil, err
+	}
+	return p.submit(ctx, fileType, converter, priority, false)
+}
+
+// Convert submits a conversion and waits for its result
+func (p *Pool) Convert(ctx context.Context, converter Converter, priority Priority) (*Result, error) {
+	job, err := p.Submit(ctx, converter, priority)
+	if err != nil {
+		return nil, err
+	}
+	return job.Wait(ctx)
+}
+
+// submit queues a job, waiting for room when wait is true
+func (p *Pool) submit(ctx context.Context, fileType FileType, converter Converter, priority Priority, wait bool) (*Job, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	group, ok := p.groups[fileType]
+	if !ok {
+		return nil, fmt.Errorf("%w: no workers for file type %s", ErrInvalidOption, fileType)
+	}
+
+	if wait && p.queued >= p.config.QueueSize {
+		// Wake up the waiters when ctx is done, sync.Cond can not select on a channel
+		stop := context.AfterFunc(ctx, func() {
+			p.mu.Lock()
+			p.space.Broadcast()
+			p.mu.Unlock()
+		})
+		defer stop()
+	}
+	for !p.closed && p.queued >= p.config.QueueSize {
+		if !wait {
+			return nil, ErrQueueFull
+		}
+		if err := ctx.Err(); err != nil {
+			// This waiter may have taken the wakeup of a worker, pass it on to the next one
+			p.space.Signal()
+			return nil, contextError(ctx, err)
+		}
+		p.space.Wait()
+	}
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+
+	p.pruneJobs()
+	p.seq++
+	job := &Job{
+		ID:        uuid.NewString(),
+		Type:      fileType,
+		Priority:  priority,
+		ctx:       ctx,
+		converter: converter,
+		seq:       p.seq,
+		status:    JobQueued,
+		queuedAt:  time.Now(),
+		done:      make(chan struct{}),
+	}
+	heap.Push(&group.queue, job)
+	p.jobs[job.ID] = job
+	p.queued++
+	group.work.Signal()
+	return job, nil
+}
+
+// worker runs the jobs of a media type until the pool is closed and the queue is empty
+func (p *Pool) worker(group *workerGroup) {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for len(group.queue) == 0 && !p.closed {
+			group.work.Wait()
+		}
+		if len(group.queue) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		job := heap.Pop(&group.queue).(*Job)
+		p.queued--
+		p.space.Signal()
+
+		// A job whose context is done while it is queued is not started
+		if err := job.ctx.Err(); err != nil {
+			group.failed++
+			p.mu.Unlock()
+			job.finish(nil, contextError(job.ctx, err))
+			continue
+		}
+
+		now := time.Now()
+		wait := now.Sub(job.queuedAt)
+		group.running++
+		group.started++
+		group.totalWait += wait
+		if wait > group.maxWait {
+			group.maxWait = wait
+		}
+		p.mu.Unlock()
+
+		job.mu.Lock()
+		job.status = JobRunning
+		job.startedAt = now
+		job.mu.Unlock()
+
+		result, err := job.converter.ConvertContext(job.ctx)
+		job.finish(result, err)
+
+		p.mu.Lock()
+		group.running--
+		if err != nil {
+			group.failed++
+		} else {
+			group.done++
+		}
+		p.mu.Unlock()
+	}
+}
+
+// pruneJobs forgets finished jobs older than the retention, p.mu must be held
+func (p *Pool) pruneJobs() {
+	deadline := time.Now().Add(-p.config.JobRetention)
+	for id, job := range p.jobs {
+		job.mu.Lock()
+		expired := !job.finishedAt.IsZero() && job.finishedAt.Before(deadline)
+		job.mu.Unlock()
+		if expired {
+			delete(p.jobs, id)
+		}
+	}
+}
+
+// Job returns a submitted job by id. Finished jobs are kept for PoolConfig.JobRetention.
+func (p *Pool) Job(id string) (*Job, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job, ok := p.jobs[id]
+	return job, ok
+}
+
+// Stats returns the queue depth, the running jobs and the wait times of each media type
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := PoolStats{
+		QueueDepth: p.queued,
+		QueueSize:  p.config.QueueSize,
+		Types:      make(map[FileType]TypeStats, len(p.groups)),
+	}
+	for fileType, group := range p.groups {
+		typeStats := TypeStats{
+			Workers: group.workers,
+			Queued:  len(group.queue),
+			Running: group.running,
+			Done:    group.done,
+			Failed:  group.failed,
+			MaxWait: group.maxWait,
+		}
+		if group.started > 0 {
+			typeStats.AvgWait = group.totalWait / time.Duration(group.started)
+		}
+		stats.Types[fileType] = typeStats
+	}
+	return stats
+}
+
+// Close stops accepting jobs and waits until the queued and running jobs are finished
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	for _, group := range p.groups {
+		group.work.Broadcast()
+	}
+	p.space.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+}
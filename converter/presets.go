@@ -0,0 +1,387 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PresetKind is the config a preset expands into
+type PresetKind string
+
+const (
+	PresetImage PresetKind = "image" // ImageConfig
+	PresetLogo  PresetKind = "logo"  // LogoConfig
+	PresetVideo PresetKind = "video" // VideoConfig
+	PresetAudio PresetKind = "audio" // AudioConfig
+)
+
+// Preset is a named set of conversion settings.
+// Only the fields of its kind are used, the others must be zero.
+type Preset struct {
+	Name                  string     `json:"name" yaml:"name"`                                                       // Name of the preset, for example "avatar"
+	Kind                  PresetKind `json:"kind" yaml:"kind"`                                                       // Config the preset expands into
+	FormatToConvert       string     `json:"format" yaml:"format"`                                                   // Output format
+	Width                 int        `json:"width,omitempty" yaml:"width,omitempty"`                                 // Target width for images and videos
+	Height                int        `json:"height,omitempty" yaml:"height,omitempty"`                               // Target height for images and videos
+	StretchThreshold      float64    `json:"stretchThreshold,omitempty" yaml:"stretchThreshold,omitempty"`           // Stretch threshold for images
+	Quality               int        `json:"quality,omitempty" yaml:"quality,omitempty"`                             // Quality level 1-5 for images and videos
+	TransparentBackground bool       `json:"transparentBackground,omitempty" yaml:"transparentBackground,omitempty"` // Transparent background for images and videos
+	Bitrate               int        `json:"bitrate,omitempty" yaml:"bitrate,omitempty"`                             // Bitrate in kbps for audio
+	MaxWidth              int        `json:"maxWidth,omitempty" yaml:"maxWidth,omitempty"`                           // Maximum width for logos
+	MaxHeight             int        `json:"maxHeight,omitempty" yaml:"maxHeight,omitempty"`                         // Maximum height for logos
+	MinWidth              int        `json:"minWidth,omitempty" yaml:"minWidth,omitempty"`                           // Minimum width for logos
+	MinHeight             int        `json:"minHeight,omitempty" yaml:"minHeight,omitempty"`                         // Minimum height for logos
+}
+
+// PresetOverrides are the fields of a Preset to change with With.
+// A nil field keeps the value of the preset, a set field replaces it, even with a zero value.
+type PresetOverrides struct {
+	FormatToConvert       *string  `json:"format,omitempty"`                // Output format
+	Width                 *int     `json:"width,omitempty"`                 // Target width for images and videos
+	Height                *int     `json:"height,omitempty"`                // Target height for images and videos
+	StretchThreshold      *float64 `json:"stretchThreshold,omitempty"`      // Stretch threshold for images
+	Quality               *int     `json:"quality,omitempty"`               // Quality level 1-5 for images and videos
+	TransparentBackground *bool    `json:"transparentBackground,omitempty"` // Transparent background for images and videos
+	Bitrate               *int     `json:"bitrate,omitempty"`               // Bitrate in kbps for audio
+	MaxWidth              *int     `json:"maxWidth,omitempty"`              // Maximum width for logos
+	MaxHeight             *int     `json:"maxHeight,omitempty"`             // Maximum height for logos
+	MinWidth              *int     `json:"minWidth,omitempty"`              // Minimum width for logos
+	MinHeight             *int     `json:"minHeight,omitempty"`             // Minimum height for logos
+}
+
+// defaultPresets are registered on init
+var defaultPresets = []Preset{
+	{Name: "avatar", Kind: PresetImage, FormatToConvert: WEBP, Width: 256, Height: 256, StretchThreshold: 10, Quality: 4},
+	{Name: "og-image", Kind: PresetImage, FormatToConvert: JPG, Width: 1200, Height: 630, StretchThreshold: 10, Quality: 4},
+	{Name: "product-thumb", Kind: PresetImage, FormatToConvert: WEBP, Width: 400, Height: 400, Quality: 4, TransparentBackground: true},
+	{Name: "voice-note", Kind: PresetAudio, FormatToConvert: OPUS, Bitrate: 64},
+	{Name: "reel-720p", Kind: PresetVideo, FormatToConvert: MP4, Width: 720, Height: 1280, Quality: 3},
+}
+
+var (
+	presetsMu sync.RWMutex
+	presets   = make(map[string]Preset)
+)
+
+func init() {
+	for _, preset := range defaultPresets {
+		if err := RegisterPreset(preset); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterPreset validates the preset and adds or replaces it in the registry
+func RegisterPreset(preset Preset) error {
+	if err := preset.Validate(); err != nil {
+		return err
+	}
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	presets[preset.Name] = preset
+	return nil
+}
+
+// GetPreset returns the registered preset with the name
+func GetPreset(name string) (Preset, bool) {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	preset, ok := presets[name]
+	return preset, ok
+}
+
+// PresetNames returns the names of the registered presets in alphabetical order
+func PresetNames() []string {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadPresetsFile loads presets from a ".json", ".yaml" or ".yml" file and registers them.
+// The file maps preset names to presets. Nothing is registered when a preset is invalid.
+func LoadPresetsFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read presets file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return LoadPresets(bytes.NewReader(data), "json")
+	case ".yaml", ".yml":
+		return LoadPresets(bytes.NewReader(data), "yaml")
+	default:
+		return fmt.Errorf("%w: unsupported presets file: %s", ErrUnsupportedFormat, path)
+	}
+}
+
+// LoadPresets loads presets in the "json" or "yaml" format and registers them.
+// Unknown fields are rejected. Nothing is registered when a preset is invalid.
+func LoadPresets(r io.Reader, format string) error {
+	loaded := make(map[string]Preset)
+	switch format {
+	case "json":
+		decoder := json.NewDecoder(r)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&loaded); err != nil {
+			return fmt.Errorf("%w: error parsing presets: %w", ErrInvalidOption, err)
+		}
+	case "yaml":
+		decoder := yaml.NewDecoder(r)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&loaded); err != nil && err != io.EOF {
+			return fmt.Errorf("%w: error parsing presets: %w", ErrInvalidOption, err)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported presets format: %s", ErrUnsupportedFormat, format)
+	}
+
+	// Validate everything before registering anything
+	names := make([]string, 0, len(loaded))
+	for name, preset := range loaded {
+		if preset.Name != "" && preset.Name != name {
+			return fmt.Errorf("%w: preset %q has name %q", ErrInvalidOption, name, preset.Name)
+		}
+		preset.Name = name
+		if err := preset.Validate(); err != nil {
+			return err
+		}
+		loaded[name] = preset
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := RegisterPreset(loaded[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the preset with the same rules as the config it expands into
+func (p Preset) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("%w: preset name is required", ErrInvalidOption)
+	}
+
+	var err error
+	switch p.Kind {
+	case PresetImage:
+		err = p.validateImage()
+	case PresetLogo:
+		err = p.validateLogo()
+	case PresetVideo:
+		err = p.validateVideo()
+	case PresetAudio:
+		err = p.validateAudio()
+	default:
+		err = fmt.Errorf("%w: unknown kind %q", ErrInvalidOption, p.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("preset %q: %w", p.Name, err)
+	}
+	return nil
+}
+
+// validateImage checks the fields used by ImageConfig
+func (p Preset) validateImage() error {
+	if p.Width <= 0 || p.Width > 8192 || p.Height <= 0 || p.Height > 8192 {
+		return fmt.Errorf("%w: width and height must be greater than 0 and less than 8192", ErrInvalidDimensions)
+	}
+	if p.StretchThreshold < 0 || p.StretchThreshold > 100 {
+		return fmt.Errorf("%w: stretch threshold must be between 0 and 100", ErrInvalidOption)
+	}
+	if p.Quality < 1 || p.Quality > 5 {
+		return fmt.Errorf("%w: quality must be between 1 and 5", ErrInvalidOption)
+	}
+	if !contains(supportedFormatsImage, p.FormatToConvert) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, p.FormatToConvert)
+	}
+	return p.unused("bitrate", p.Bitrate != 0, "maxWidth/maxHeight/minWidth/minHeight", p.MaxWidth != 0 || p.MaxHeight != 0 || p.MinWidth != 0 || p.MinHeight != 0)
+}
+
+// validateLogo checks the fields used by LogoConfig
+func (p Preset) validateLogo() error {
+	if !contains([]string{PNG, WEBP, JPG}, p.FormatToConvert) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, p.FormatToConvert)
+	}
+	if p.MaxWidth <= 0 || p.MaxHeight <= 0 {
+		return fmt.Errorf("%w: max width and max height must be greater than 0", ErrInvalidDimensions)
+	}
+	if p.MinWidth < 0 || p.MinHeight < 0 || p.MinWidth > p.MaxWidth || p.MinHeight > p.MaxHeight {
+		return fmt.Errorf("%w: min width and min height must be between 0 and the max values", ErrInvalidDimensions)
+	}
+	return p.unused("width/height", p.Width != 0 || p.Height != 0, "quality", p.Quality != 0, "bitrate", p.Bitrate != 0)
+}
+
+// validateVideo checks the fields used by VideoConfig
+func (p Preset) validateVideo() error {
+	if p.Width <= 0 || p.Height <= 0 {
+		return fmt.Errorf("%w: width and height must be greater than 0", ErrInvalidDimensions)
+	}
+	if p.Quality < 1 || p.Quality > 5 {
+		return fmt.Errorf("%w: quality must be between 1 and 5", ErrInvalidOption)
+	}
+	if !contains(supportedFormatsVideo, p.FormatToConvert) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, p.FormatToConvert)
+	}
+	return p.unused("bitrate", p.Bitrate != 0, "stretchThreshold", p.StretchThreshold != 0)
+}
+
+// validateAudio checks the fields used by AudioConfig
+func (p Preset) validateAudio() error {
+	if p.Bitrate < 64 || p.Bitrate > 320 {
+		return fmt.Errorf("%w: bitrate must be between 64 and 320 kbps", ErrInvalidOption)
+	}
+	if !contains(supportedFormatsAudio, p.FormatToConvert) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, p.FormatToConvert)
+	}
+	return p.unused("width/height", p.Width != 0 || p.Height != 0, "quality", p.Quality != 0)
+}
+
+// unused returns ErrInvalidOption for the first field that is set but not used by the kind.
+// The arguments are pairs of field names and whether the field is set.
+func (p Preset) unused(fields ...any) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if set, _ := fields[i+1].(bool); set {
+			return fmt.Errorf("%w: %v is not used by %s presets", ErrInvalidOption, fields[i], p.Kind)
+		}
+	}
+	return nil
+}
+
+// With returns a copy of the preset with the set fields of overrides applied.
+// The result is validated, the name and the kind can not be overridden.
+func (p Preset) With(overrides PresetOverrides) (Preset, error) {
+	if overrides.FormatToConvert != nil {
+		p.FormatToConvert = *overrides.FormatToConvert
+	}
+	if overrides.Width != nil {
+		p.Width = *overrides.Width
+	}
+	if overrides.Height != nil {
+		p.Height = *overrides.Height
+	}
+	if overrides.StretchThreshold != nil {
+		p.StretchThreshold = *overrides.StretchThreshold
+	}
+	if overrides.Quality != nil {
+		p.Quality = *overrides.Quality
+	}
+	if overrides.TransparentBackground != nil {
+		p.TransparentBackground = *overrides.TransparentBackground
+	}
+	if overrides.Bitrate != nil {
+		p.Bitrate = *overrides.Bitrate
+	}
+	if overrides.MaxWidth != nil {
+		p.MaxWidth = *overrides.MaxWidth
+	}
+	if overrides.MaxHeight != nil {
+		p.MaxHeight = *overrides.MaxHeight
+	}
+	if overrides.MinWidth != nil {
+		p.MinWidth = *overrides.MinWidth
+	}
+	if overrides.MinHeight != nil {
+		p.MinHeight = *overrides.MinHeight
+	}
+	return p, p.Validate()
+}
+
+// kindError returns ErrInvalidOption when the preset is not of the kind
+func (p Preset) kindError(kind PresetKind) error {
+	if p.Kind != kind {
+		return fmt.Errorf("%w: preset %q has kind %s, not %s", ErrInvalidOption, p.Name, p.Kind, kind)
+	}
+	return nil
+}
+
+// ImageConfig expands an image preset. Fields of the returned config can be changed per call.
+func (p Preset) ImageConfig(fileName string, file io.Reader) (*ImageConfig, error) {
+	if err := p.kindError(PresetImage); err != nil {
+		return nil, err
+	}
+	return &ImageConfig{
+		FileName:              fileName,
+		File:                  file,
+		Width:                 p.Width,
+		Height:                p.Height,
+		FormatToConvert:       p.FormatToConvert,
+		StretchThreshold:      p.StretchThreshold,
+		Quality:               p.Quality,
+		TransparentBackground: p.TransparentBackground,
+	}, nil
+}
+
+// LogoConfig expands a logo preset. Fields of the returned config can be changed per call.
+func (p Preset) LogoConfig(fileName string, file io.Reader) (*LogoConfig, error) {
+	if err := p.kindError(PresetLogo); err != nil {
+		return nil, err
+	}
+	return &LogoConfig{
+		FileName:        fileName,
+		File:            file,
+		FormatToConvert: p.FormatToConvert,
+		MaxWidth:        p.MaxWidth,
+		MaxHeight:       p.MaxHeight,
+		MinWidth:        p.MinWidth,
+		MinHeight:       p.MinHeight,
+	}, nil
+}
+
+// VideoConfig expands a video preset. Fields of the returned config can be changed per call.
+func (p Preset) VideoConfig(fileName string, file io.Reader) (*VideoConfig, error) {
+	if err := p.kindError(PresetVideo); err != nil {
+		return nil, err
+	}
+	return &VideoConfig{
+		FileName:              fileName,
+		File:                  file,
+		Width:                 p.Width,
+		Height:                p.Height,
+		FormatToConvert:       p.FormatToConvert,
+		Quality:               p.Quality,
+		TransparentBackground: p.TransparentBackground,
+	}, nil
+}
+
+// AudioConfig expands an audio preset. Fields of the returned config can be changed per call.
+func (p Preset) AudioConfig(fileName string, file io.Reader) (*AudioConfig, error) {
+	if err := p.kindError(PresetAudio); err != nil {
+		return nil, err
+	}
+	return &AudioConfig{
+		FileName:        fileName,
+		File:            file,
+		Bitrate:         p.Bitrate,
+		FormatToConvert: p.FormatToConvert,
+	}, nil
+}
+
+// Options returns the preset as Options for Auto, logo fields are not part of Options
+func (p Preset) Options() Options {
+	return Options{
+		FormatToConvert:       p.FormatToConvert,
+		Width:                 p.Width,
+		Height:                p.Height,
+		StretchThreshold:      p.StretchThreshold,
+		Quality:               p.Quality,
+		TransparentBackground: p.TransparentBackground,
+		Bitrate:               p.Bitrate,
+	}
+}
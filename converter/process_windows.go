@@ -0,0 +1,27 @@
+//go:build windows
+
+package converter
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup is a no-op on Windows, the process tree is killed with taskkill
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessTree kills the process and its children
+func killProcessTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// killedByRlimit is always false on Windows, rlimits are not applied
+func killedByRlimit(err error) bool {
+	return false
+}
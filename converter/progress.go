@@ -0,0 +1,62 @@
+package converter
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// timeRegex matches the position printed by ffmpeg in its status line, for example "time=00:00:12.34"
+var timeRegex = regexp.MustCompile(`time=(\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// progressWriter reads the ffmpeg log while writing it to w and reports the position
+// of the conversion as a fraction of the input duration
+type progressWriter struct {
+	w        io.Writer
+	progress func(float64)
+	duration time.Duration
+	line     []byte
+}
+
+// newProgressWriter wraps w, it returns w when progress is nil
+func newProgressWriter(w io.Writer, progress func(float64)) io.Writer {
+	if progress == nil {
+		return w
+	}
+	return &progressWriter{w: w, progress: progress}
+}
+
+// Write writes p to the underlying writer and parses the complete lines.
+// ffmpeg ends its status lines with "\r", the log lines with "\n".
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.line = append(pw.line, p[:n]...)
+	for {
+		index := bytes.IndexAny(pw.line, "\r\n")
+		if index < 0 {
+			break
+		}
+		pw.parse(pw.line[:index])
+		pw.line = pw.line[index+1:]
+	}
+	return n, err
+}
+
+// parse reads the input duration or the current position from a line of the log
+func (pw *progressWriter) parse(line []byte) {
+	if pw.duration == 0 {
+		pw.duration = parseFFmpegDuration(string(line))
+		return
+	}
+	match := timeRegex.FindSubmatch(line)
+	if match == nil {
+		return
+	}
+	hours, _ := strconv.Atoi(string(match[1]))
+	minutes, _ := strconv.Atoi(string(match[2]))
+	seconds, _ := strconv.ParseFloat(string(match[3]), 64)
+	position := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+	pw.progress(min(float64(position)/float64(pw.duration), 1))
+}
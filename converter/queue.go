@@ -0,0 +1,604 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Errors returned by Queue
+var (
+	ErrJobNotFound = errors.New("job not found")       // no job with the id, or it was removed after the retention
+	ErrQueueClosed = errors.New("job queue is closed") // the queue does not accept jobs anymore
+)
+
+// Task describes a conversion run by a Queue. It is stored as JSON with the job,
+// so it holds settings only; the file is copied to the queue directory on Submit.
+type Task struct {
+	FileName     string           `json:"fileName"`               // Original file name, it selects the media type
+	Preset       string           `json:"preset,omitempty"`       // Registered preset to use, resolved on Submit
+	Settings     Preset           `json:"settings"`               // Conversion settings without Preset, replaced by the resolved settings on Submit
+	Overrides    *PresetOverrides `json:"overrides,omitempty"`    // Fields of Preset to change
+	Key          string           `json:"key,omitempty"`          // Key of the converted file in the storage
+	NameStrategy NameStrategy     `json:"nameStrategy,omitempty"` // Strategy for the default key
+}
+
+// TaskState is the stored state of a job of a Queue
+type TaskState struct {
+	ID          string    `json:"id"`
+	Task        Task      `json:"task"`
+	Status      JobStatus `json:"status"`
+	Progress    float64   `json:"progress"`              // Converted fraction 0-1, reported by ffmpeg for videos and audio
+	Attempts    int       `json:"attempts"`              // Started attempts, including the running one
+	Error       string    `json:"error,omitempty"`       // Error of the last attempt
+	Result      *Result   `json:"result,omitempty"`      // Result of a done job
+	CreatedAt   time.Time `json:"createdAt"`             // Time of Submit
+	UpdatedAt   time.Time `json:"updatedAt"`             // Time of the last change of the status
+	NextAttempt time.Time `json:"nextAttempt,omitempty"` // Earliest start of a retried job
+}
+
+// finished reports whether the job is done or failed
+func (s *TaskState) finished() bool {
+	return s.Status == JobDone || s.Status == JobFailed
+}
+
+// QueueConfig holds configuration for a Queue
+type QueueConfig struct {
+	Dir          string           // Directory of the stored jobs and their input files. Required
+	DirToStorage string           // Directory to store the converted files, used when Storage is nil
+	Storage      Storage          // Storage for the converted files
+	Limits       Limits           // Limits for source images
+	Sandbox      Sandbox          // Limits of the ffmpeg runs
+	Cache        *Cache           // Cache of converted outputs, nil disables caching
+	Workers      int              // Concurrent conversions. Default: 1
+	MaxAttempts  int              // Attempts of a job before it fails, 1 disables retries. Default: 3
+	RetryDelay   time.Duration    // Delay before the first retry, doubled for each further retry. Default: 10 seconds
+	Retention    time.Duration    // How long finished jobs are kept. Default: 24 hours
+	Retryable    func(error) bool // Reports whether a failed attempt is retried. Default: IsTransient
+}
+
+// Queue runs conversions in the background. Jobs are stored in a directory, so queued jobs
+// and jobs interrupted by a restart are run again when a Queue is opened on the same directory.
+type Queue struct {
+	config QueueConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu          sync.Mutex
+	tasks       map[string]*TaskState
+	subscribers map[string][]chan TaskState
+	wake        chan struct{} // closed and replaced when a job becomes runnable
+	closed      bool
+}
+
+// IsTransient reports whether a failed conversion may succeed when it is run again.
+// Invalid settings, unsupported or undecodable input, missing tools, exceeded limits,
+// cancellations, including the sandbox timeout, and ffmpeg exiting with an error status are
+// permanent. ffmpeg killed by a signal, encoder and storage errors are transient.
+func IsTransient(err error) bool {
+	for _, permanent := range []error{
+		ErrUnsupportedFormat, ErrInvalidDimensions, ErrInvalidOption, ErrDecode,
+		ErrToolMissing, ErrCanceled, ErrContentMismatch, ErrLimitExceeded,
+	} {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	var ffmpegErr *FFmpegError
+	if errors.As(err, &ffmpegErr) {
+		// An error status means ffmpeg rejected the input or the settings, it fails the same way again
+		return ffmpegErr.ExitCode < 0
+	}
+	return err != nil
+}
+
+// NewQueue opens the queue stored in config.Dir and starts its workers.
+// Jobs that were running when the previous process stopped are queued again.
+func NewQueue(config QueueConfig) (*Queue, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("%w: queue dir is required", ErrInvalidOption)
+	}
+	if config.Storage == nil && config.DirToStorage == "" {
+		return nil, fmt.Errorf("%w: storage or dir to storage is required", ErrInvalidOption)
+	}
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 3
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = 10 * time.Second
+	}
+	if config.Retention <= 0 {
+		config.Retention = 24 * time.Hour
+	}
+	if config.Retryable == nil {
+		config.Retryable = IsTransient
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create queue dir: %w", err)
+	}
+
+	q := &Queue{
+		config:      config,
+		tasks:       make(map[string]*TaskState),
+		subscribers: make(map[string][]chan TaskState),
+		wake:        make(chan struct{}),
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	for i := 0; i < config.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	return q, nil
+}
+
+// load reads the stored jobs, running jobs were interrupted and are queued again
+func (q *Queue) load() error {
+	entries, err := os.ReadDir(q.config.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read queue dir: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			// Left by a write that was interrupted
+			os.Remove(filepath.Join(q.config.Dir, name))
+			continue
+		}
+		if filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(q.config.Dir, name))
+		if err != nil {
+			return fmt.Errorf("failed to read job: %w", err)
+		}
+		var state TaskState
+		err = json.Unmarshal(data, &state)
+		if err == nil && state.ID == "" {
+			err = errors.New("missing job id")
+		}
+		if err != nil {
+			// One damaged job must not stop the queue, it is kept aside for inspection
+			log.Printf("converter: skipping corrupt job %s: %v", name, err)
+			os.Rename(filepath.Join(q.config.Dir, name), filepath.Join(q.config.Dir, name+".corrupt"))
+			continue
+		}
+		if state.Status == JobRunning {
+			state.Status = JobQueued
+			state.Attempts--
+			if err := q.save(&state); err != nil {
+				return err
+			}
+		}
+		q.tasks[state.ID] = &state
+	}
+	q.prune()
+	return nil
+}
+
+// Submit validates the task, stores it with a copy of the file and returns the job id.
+// The preset of the task is resolved now, so a job does not depend on the presets
+// registered when it runs.
+func (q *Queue) Submit(task Task, file io.Reader) (string, error) {
+	settings, err := task.resolve()
+	if err != nil {
+		return "", err
+	}
+	task.Settings = settings
+
+	_, file, err = SniffFileType(task.FileName, file)
+	if err != nil {
+		return "", err
+	}
+
+	state := &TaskState{
+		ID:        uuid.NewString(),
+		Task:      task,
+		Status:    JobQueued,
+		CreatedAt: time.Now(),
+	}
+	state.UpdatedAt = state.CreatedAt
+	if err := writeFileAtomic(q.inputPath(state.ID), file); err != nil {
+		return "", err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		os.Remove(q.inputPath(state.ID))
+		return "", ErrQueueClosed
+	}
+	if err := q.save(state); err != nil {
+		os.Remove(q.inputPath(state.ID))
+		return "", err
+	}
+	q.tasks[state.ID] = state
+	q.notifyWorkers()
+	return state.ID, nil
+}
+
+// resolve returns the settings of the task with its preset applied and checks the media type
+func (t Task) resolve() (Preset, error) {
+	if err := validateFileName(t.FileName); err != nil {
+		return Preset{}, err
+	}
+
+	settings := t.Settings
+	if t.Preset != "" {
+		preset, ok := GetPreset(t.Preset)
+		if !ok {
+			return Preset{}, fmt.Errorf("%w: unknown preset %q", ErrInvalidOption, t.Preset)
+		}
+		settings = preset
+	}
+	if settings.Name == "" {
+		settings.Name = "task"
+	}
+	var overrides PresetOverrides
+	if t.Overrides != nil {
+		overrides = *t.Overrides
+	}
+	settings, err := settings.With(overrides)
+	if err != nil {
+		return Preset{}, err
+	}
+
+	fileType := DetermineFileType(t.FileName)
+	kindType := map[PresetKind]FileType{PresetImage: Image, PresetLogo: Image, PresetVideo: Video, PresetAudio: Audio}[settings.Kind]
+	if fileType != kindType {
+		return Preset{}, fmt.Errorf("%w: %s settings can not convert %s", ErrUnsupportedFormat, settings.Kind, t.FileName)
+	}
+	return settings, nil
+}
+
+// Status returns the state of a job
+func (q *Queue) Status(id string) (TaskState, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	state, ok := q.tasks[id]
+	if !ok {
+		return TaskState{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return *state, nil
+}
+
+// Jobs returns the states of all stored jobs, oldest first
+func (q *Queue) Jobs() []TaskState {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	states := make([]TaskState, 0, len(q.tasks))
+	for _, state := range q.tasks {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, k int) bool {
+		return states[i].CreatedAt.Before(states[k].CreatedAt)
+	})
+	return states
+}
+
+// Subscribe returns a channel that receives the state of a job on every change, starting with
+// the current state. Only the latest state is kept for a slow reader. The channel is closed
+// after the job is done or failed, or when stop is called.
+func (q *Queue) Subscribe(id string) (<-chan TaskState, func(), error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	state, ok := q.tasks[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	ch := make(chan TaskState, 1)
+	ch <- *state
+	if state.finished() {
+		close(ch)
+		return ch, func() {}, nil
+	}
+	q.subscribers[id] = append(q.subscribers[id], ch)
+
+	stop := func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		subscribers := q.subscribers[id]
+		for i, subscriber := range subscribers {
+			if subscriber == ch {
+				q.subscribers[id] = append(subscribers[:i], subscribers[i+1:]...)
+				close(ch)
+				break
+			}
+		}
+		if len(q.subscribers[id]) == 0 {
+			delete(q.subscribers, id)
+		}
+	}
+	return ch, stop, nil
+}
+
+// publish sends the state to the subscribers of the job, q.mu must be held.
+// A pending state that was not read yet is replaced.
+func (q *Queue) publish(state *TaskState) {
+	for _, ch := range q.subscribers[state.ID] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- *state
+		if state.finished() {
+			close(ch)
+		}
+	}
+	if state.finished() {
+		delete(q.subscribers, state.ID)
+	}
+}
+
+// notifyWorkers wakes the idle workers, q.mu must be held
+func (q *Queue) notifyWorkers() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// worker runs the due jobs until the queue is closed
+func (q *Queue) worker() {
+	defer q.wg.Done()
+	for {
+		state, wake, delay := q.next()
+		if state != nil {
+			q.run(state)
+			continue
+		}
+
+		// Without a pending retry the nil channel never fires
+		var retry <-chan time.Time
+		var timer *time.Timer
+		if delay > 0 {
+			timer = time.NewTimer(delay)
+			retry = timer.C
+		}
+		select {
+		case <-wake:
+		case <-retry:
+		case <-q.ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if q.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// next marks the oldest due job as running and returns a copy of it.
+// Without a due job it returns the channel closed on new jobs and the delay until the next retry.
+func (q *Queue) next() (*TaskState, <-chan struct{}, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.prune()
+	if q.closed {
+		return nil, q.wake, 0
+	}
+
+	now := time.Now()
+	var due *TaskState
+	var delay time.Duration
+	for _, state := range q.tasks {
+		if state.Status != JobQueued {
+			continue
+		}
+		if wait := state.NextAttempt.Sub(now); wait > 0 {
+			if delay == 0 || wait < delay {
+				delay = wait
+			}
+			continue
+		}
+		if due == nil || state.CreatedAt.Before(due.CreatedAt) {
+			due = state
+		}
+	}
+	if due == nil {
+		return nil, q.wake, delay
+	}
+
+	due.Status = JobRunning
+	due.Attempts++
+	due.Progress = 0
+	due.UpdatedAt = now
+	// When the state can not be saved the job stays queued on disk and runs again after a restart
+	_ = q.save(due)
+	q.publish(due)
+	state := *due
+	return &state, nil, 0
+}
+
+// run converts a job and stores the outcome
+func (q *Queue) run(state *TaskState) {
+	result, err := q.convert(state)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	current := q.tasks[state.ID]
+	current.UpdatedAt = time.Now()
+	current.Error = ""
+
+	switch {
+	case err == nil:
+		current.Status = JobDone
+		current.Progress = 1
+		current.Result = result
+	case q.ctx.Err() != nil:
+		// Interrupted by Close, the attempt does not count
+		current.Status = JobQueued
+		current.Attempts--
+	case current.Attempts < q.config.MaxAttempts && q.config.Retryable(err):
+		current.Status = JobQueued
+		current.Error = err.Error()
+		current.NextAttempt = current.UpdatedAt.Add(q.config.RetryDelay << (current.Attempts - 1))
+	default:
+		current.Status = JobFailed
+		current.Error = err.Error()
+	}
+
+	if current.finished() {
+		os.Remove(q.inputPath(current.ID))
+	}
+	// When the state can not be saved the job is running on disk and runs again after a restart
+	_ = q.save(current)
+	q.publish(current)
+}
+
+// convert runs the conversion of a job from its stored input file
+func (q *Queue) convert(state *TaskState) (*Result, error) {
+	file, err := os.Open(q.inputPath(state.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job input: %w", err)
+	}
+	defer file.Close()
+
+	progress := func(fraction float64) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if current := q.tasks[state.ID]; current.Status == JobRunning {
+			current.Progress = fraction
+			q.publish(current)
+		}
+	}
+
+	task := state.Task
+	storage := resolveStorage(q.config.Storage, q.config.DirToStorage)
+	var converter Converter
+	switch task.Settings.Kind {
+	case PresetImage:
+		config, err := task.Settings.ImageConfig(task.FileName, file)
+		if err != nil {
+			return nil, err
+		}
+		config.Storage, config.Key, config.NameStrategy, config.Limits = storage, task.Key, task.NameStrategy, q.config.Limits
+		config.Cache = q.config.Cache
+		converter = config
+	case PresetLogo:
+		config, err := task.Settings.LogoConfig(task.FileName, file)
+		if err != nil {
+			return nil, err
+		}
+		config.Storage, config.Key, config.NameStrategy, config.Limits = storage, task.Key, task.NameStrategy, q.config.Limits
+		config.Cache = q.config.Cache
+		converter = config
+	case PresetVideo:
+		config, err := task.Settings.VideoConfig(task.FileName, file)
+		if err != nil {
+			return nil, err
+		}
+		config.Storage, config.Key, config.NameStrategy, config.Sandbox, config.Progress = storage, task.Key, task.NameStrategy, q.config.Sandbox, progress
+		config.Cache = q.config.Cache
+		converter = config
+	case PresetAudio:
+		config, err := task.Settings.AudioConfig(task.FileName, file)
+		if err != nil {
+			return nil, err
+		}
+		config.Storage, config.Key, config.NameStrategy, config.Sandbox, config.Progress = storage, task.Key, task.NameStrategy, q.config.Sandbox, progress
+		config.Cache = q.config.Cache
+		converter = config
+	default:
+		return nil, fmt.Errorf("%w: unknown kind %q", ErrInvalidOption, task.Settings.Kind)
+	}
+	return converter.ConvertContext(q.ctx)
+}
+
+// prune removes the finished jobs older than the retention, q.mu must be held
+func (q *Queue) prune() {
+	deadline := time.Now().Add(-q.config.Retention)
+	for id, state := range q.tasks {
+		if state.finished() && state.UpdatedAt.Before(deadline) {
+			os.Remove(q.statePath(id))
+			os.Remove(q.inputPath(id))
+			delete(q.tasks, id)
+		}
+	}
+}
+
+// save writes the state of a job to the queue directory
+func (q *Queue) save(state *TaskState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+	return writeFileAtomic(q.statePath(state.ID), bytes.NewReader(data))
+}
+
+// statePath returns the path of the stored state of a job
+func (q *Queue) statePath(id string) string {
+	return filepath.Join(q.config.Dir, id+".json")
+}
+
+// inputPath returns the path of the stored input file of a job
+func (q *Queue) inputPath(id string) string {
+	return filepath.Join(q.config.Dir, id+".input")
+}
+
+// Close stops the workers. Running conversions are canceled and their jobs stay queued,
+// they are run again when the queue is opened next time.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cancel()
+	q.wg.Wait()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, subscribers := range q.subscribers {
+		for _, ch := range subscribers {
+			close(ch)
+		}
+		delete(q.subscribers, id)
+	}
+}
+
+// writeFileAtomic writes the content to a temporary file, syncs and renames it,
+// so a crash never leaves a partial file behind
+func writeFileAtomic(path string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+	return nil
+}
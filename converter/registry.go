@@ -0,0 +1,176 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Converter is implemented by every media configuration (ImageConfig, LogoConfig, VideoConfig, AudioConfig)
+type Converter interface {
+	Convert() (*Result, error)
+	ConvertContext(ctx context.Context) (*Result, error)
+}
+
+var (
+	_ Converter = (*ImageConfig)(nil)
+	_ Converter = (*LogoConfig)(nil)
+	_ Converter = (*VideoConfig)(nil)
+	_ Converter = (*AudioConfig)(nil)
+)
+
+// Options holds the settings used by Auto to build a converter.
+// Fields that do not apply to the detected file type are ignored.
+type Options struct {
+	FormatToConvert       string        // Desired output format. Empty uses the default format of the file type
+	Width                 int           // Target width for images and videos
+	Height                int           // Target height for images and videos
+	StretchThreshold      float64       // Threshold for stretching images
+	Quality               int           // Quality level 1-5 for images and videos
+	TransparentBackground bool          // Transparent background for images and videos
+	Bitrate               int           // Bitrate in kbps for audio
+	DirToStorage          string        // Directory to store the converted file, used when Storage is nil
+	Storage               Storage       // Storage for the converted file
+	Key                   string        // Key of the converted file in the storage. Empty uses the default key of the pipeline
+	NameStrategy          NameStrategy  // Strategy for the default key
+	Limits                Limits        // Limits for source images
+	Fit                   Fit           // How images are fitted into Width and Height
+	Cache                 *Cache        // Cache of converted outputs, nil disables caching
+	Sandbox               Sandbox       // Limits of the ffmpeg run for videos and audio
+	Progress              func(float64) // Called with the converted fraction 0-1 for videos and audio
+}
+
+// Factory builds a converter for a file
+type Factory func(fileName string, file io.Reader, options Options) Converter
+
+// registryKey identifies a pipeline in the registry
+type registryKey struct {
+	fileType FileType
+	format   string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[registryKey]Factory)
+
+	// defaultFormats is the output format used by Auto when Options.FormatToConvert is empty
+	defaultFormats = map[FileType]string{
+		Image: WEBP,
+		Video: MP4,
+		Audio: MP3,
+	}
+)
+
+func init() {
+	for _, format := range []string{PNG, JPEG, JPG, WEBP} {
+		Register(Image, format, newImageConverter)
+	}
+	for _, format := range supportedFormatsVideo {
+		Register(Video, format, newVideoConverter)
+	}
+	for _, format := range supportedFormatsAudio {
+		Register(Audio, format, newAudioConverter)
+	}
+}
+
+// Register adds or replaces the converter factory for a file type and output format
+func Register(fileType FileType, format string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[registryKey{fileType, strings.ToLower(format)}] = factory
+}
+
+// Lookup returns the converter factory registered for a file type and output format
+func Lookup(fileType FileType, format string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	factory, ok := registry[registryKey{fileType, strings.ToLower(format)}]
+	return factory, ok
+}
+
+// Auto detects the file type from the file name and runs the pipeline registered for it.
+// The content is sniffed first, ErrContentMismatch is returned when it disagrees with the extension.
+// The conversion is canceled with ctx.
+func Auto(ctx context.Context, fileName string, file io.Reader, options Options) (*Result, error) {
+	fileType := DetermineFileType(fileName)
+	if fileType == Unknown {
+		return nil, fmt.Errorf("%w: unsupported file type: %s", ErrUnsupportedFormat, fileName)
+	}
+
+	_, file, err := SniffFileType(fileName, file)
+	if err != nil {
+		return nil, err
+	}
+
+	format := strings.ToLower(options.FormatToConvert)
+	if format == "" {
+		format = defaultFormats[fileType]
+	}
+	options.FormatToConvert = format
+
+	factory, ok := Lookup(fileType, format)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+	return factory(fileName, file, options).ConvertContext(ctx)
+}
+
+// newImageConverter builds an ImageConfig from the options
+func newImageConverter(fileName string, file io.Reader, options Options) Converter {
+	return &ImageConfig{
+		FileName:              fileName,
+		File:                  file,
+		Width:                 options.Width,
+		Height:                options.Height,
+		FormatToConvert:       options.FormatToConvert,
+		StretchThreshold:      options.StretchThreshold,
+		Quality:               options.Quality,
+		TransparentBackground: options.TransparentBackground,
+		DirToStorage:          options.DirToStorage,
+		Storage:               options.Storage,
+		Key:                   options.Key,
+		NameStrategy:          options.NameStrategy,
+		Limits:                options.Limits,
+		Fit:                   options.Fit,
+		Cache:                 options.Cache,
+	}
+}
+
+// newVideoConverter builds a VideoConfig from the options
+func newVideoConverter(fileName string, file io.Reader, options Options) Converter {
+	return &VideoConfig{
+		FileName:              fileName,
+		File:                  file,
+		Width:                 options.Width,
+		Height:                options.Height,
+		FormatToConvert:       options.FormatToConvert,
+		Quality:               options.Quality,
+		TransparentBackground: options.TransparentBackground,
+		DirToStorage:          options.DirToStorage,
+		Storage:               options.Storage,
+		Key:                   options.Key,
+		NameStrategy:          options.NameStrategy,
+		Sandbox:               options.Sandbox,
+		Progress:              options.Progress,
+		Cache:                 options.Cache,
+	}
+}
+
+// newAudioConverter builds an AudioConfig from the options
+func newAudioConverter(fileName string, file io.Reader, options Options) Converter {
+	return &AudioConfig{
+		FileName:        fileName,
+		File:            file,
+		Bitrate:         options.Bitrate,
+		FormatToConvert: options.FormatToConvert,
+		DirToStorage:    options.DirToStorage,
+		Storage:         options.Storage,
+		Key:             options.Key,
+		NameStrategy:    options.NameStrategy,
+		Sandbox:         options.Sandbox,
+		Progress:        options.Progress,
+		Cache:           options.Cache,
+	}
+}
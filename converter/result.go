@@ -0,0 +1,132 @@
+package converter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result describes a converted file
+type Result struct {
+	Path     string        `json:"path,omitempty"`     // Location of the converted file: a path for local storage, a URL otherwise
+	Key      string        `json:"key,omitempty"`      // Key of the converted file in the storage
+	MIMEType string        `json:"mimeType"`           // MIME type of the converted file
+	Size     int64         `json:"size"`               // Size of the converted file in bytes
+	Width    int           `json:"width,omitempty"`    // Width in pixels for images and videos
+	Height   int           `json:"height,omitempty"`   // Height in pixels for images and videos
+	Duration time.Duration `json:"duration,omitempty"` // Duration for audio and video
+	Codec    string        `json:"codec,omitempty"`    // Codec or encoder used for the output
+	SHA256   string        `json:"sha256"`             // Hex encoded SHA-256 of the converted file
+	Elapsed  time.Duration `json:"elapsed"`            // Time spent on the conversion
+}
+
+// mimeTypes maps output formats to MIME types
+var mimeTypes = map[string]string{
+	PNG:  "image/png",
+	JPEG: "image/jpeg",
+	JPG:  "image/jpeg",
+	WEBP: "image/webp",
+	MP3:  "audio/mpeg",
+	M4A:  "audio/mp4",
+	OPUS: "audio/ogg",
+	WAV:  "audio/wav",
+	MP4:  "video/mp4",
+	WEBM: "video/webm",
+	JSON: "application/json",
+
+	// Formats that are only detected from the content
+	formatGIF: "image/gif",
+	formatOGG: "audio/ogg",
+	formatOGV: "video/ogg",
+	formatMKV: "video/x-matroska",
+	formatMOV: "video/quicktime",
+	formatM4V: "video/x-m4v",
+	formatExe: "application/vnd.microsoft.portable-executable",
+	formatELF: "application/x-elf",
+	formatZIP: "application/zip",
+	formatPDF: "application/pdf",
+}
+
+// MIMEType returns the MIME type of an output format, "application/octet-stream" when it is unknown
+func MIMEType(format string) string {
+	if mimeType, ok := mimeTypes[format]; ok {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+// imageCodec returns the codec name of an image format, for example "jpeg" for "jpg"
+func imageCodec(format string) string {
+	return strings.TrimPrefix(MIMEType(format), "image/")
+}
+
+// newResult fills the size and the checksum of the converted file
+func newResult(path, format, codec string, start time.Time) (*Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open converted file: %w", err)
+	}
+	defer file.Close()
+
+	out := newResultWriter(io.Discard)
+	if _, err := io.Copy(out, file); err != nil {
+		return nil, fmt.Errorf("failed to read converted file: %w", err)
+	}
+
+	result := out.result(format, codec, start)
+	result.Path = path
+	return result, nil
+}
+
+// resultWriter counts and hashes the converted output while writing it to w
+type resultWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+// newResultWriter wraps w
+func newResultWriter(w io.Writer) *resultWriter {
+	return &resultWriter{w: w, hash: sha256.New()}
+}
+
+// Write writes p to the underlying writer and adds the written bytes to the checksum
+func (rw *resultWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+	rw.hash.Write(p[:n])
+	rw.size += int64(n)
+	return n, err
+}
+
+// result returns a Result with the size and the checksum of the written output
+func (rw *resultWriter) result(format, codec string, start time.Time) *Result {
+	return &Result{
+		MIMEType: MIMEType(format),
+		Size:     rw.size,
+		Codec:    codec,
+		SHA256:   hex.EncodeToString(rw.hash.Sum(nil)),
+		Elapsed:  time.Since(start),
+	}
+}
+
+// durationRegex matches the input duration printed by ffmpeg, for example "Duration: 00:01:02.50"
+var durationRegex = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// parseFFmpegDuration extracts the input duration from the ffmpeg log
+func parseFFmpegDuration(log string) time.Duration {
+	match := durationRegex.FindStringSubmatch(log)
+	if match == nil {
+		return 0
+	}
+	hours, _ := strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, _ := strconv.ParseFloat(match[3], 64)
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+}
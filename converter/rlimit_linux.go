@@ -0,0 +1,40 @@
+//go:build linux
+
+package converter
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// limitProcess sets the CPU time, address space and file size rlimits of the sandbox on a started process.
+// Limits that are not positive are not set. A process that already exited is not an error.
+func limitProcess(pid int, sandbox Sandbox) error {
+	limits := []struct {
+		resource int
+		value    int64
+	}{
+		{unix.RLIMIT_CPU, int64((sandbox.CPUTime + time.Second - 1) / time.Second)},
+		{unix.RLIMIT_AS, sandbox.MaxMemory},
+		{unix.RLIMIT_FSIZE, sandbox.MaxOutput},
+	}
+	for _, limit := range limits {
+		if limit.value <= 0 {
+			continue
+		}
+		rlimit := unix.Rlimit{Cur: uint64(limit.value), Max: uint64(limit.value)}
+		if limit.resource == unix.RLIMIT_CPU {
+			// SIGXCPU is sent at the soft limit, a hard limit equal to it would send SIGKILL instead
+			rlimit.Max += 5
+		}
+		if err := unix.Prlimit(pid, limit.resource, &rlimit, nil); err != nil {
+			if errors.Is(err, unix.ESRCH) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package converter
+
+// limitProcess is a no-op outside Linux, only the timeout of the sandbox is enforced
+func limitProcess(pid int, sandbox Sandbox) error {
+	return nil
+}
@@ -0,0 +1,112 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// allowedProtocols are the only protocols ffmpeg may open, so an input can not fetch URLs
+// or reach other files through special protocols. Local files are kept out of reach by
+// forcing the demuxer of the sniffed format: playlist demuxers (HLS, concat) are never probed.
+const allowedProtocols = "file,pipe"
+
+// Sandbox limits the resources of an ffmpeg run.
+// A zero field uses the value of DefaultSandbox, a negative field disables the limit.
+// CPUTime, MaxMemory and MaxOutput are applied as rlimits with prlimit on Linux only, right after ffmpeg starts.
+//
+// MaxMemory limits the virtual address space, not the resident memory: ffmpeg and its encoders
+// reserve much more address space than they use (libx264 needs several GiB for HD video),
+// so it is disabled by default and should be set well above the expected peak.
+type Sandbox struct {
+	Timeout   time.Duration // Wall-clock timeout of the run
+	CPUTime   time.Duration // CPU time limit (RLIMIT_CPU)
+	MaxMemory int64         // Address space limit in bytes (RLIMIT_AS), disabled by default
+	MaxOutput int64         // Maximum size of a written file in bytes (RLIMIT_FSIZE), also applied to stdout
+}
+
+// DefaultSandbox is the sandbox used when a field of Sandbox is zero
+var DefaultSandbox = Sandbox{
+	Timeout:   10 * time.Minute,
+	CPUTime:   20 * time.Minute,
+	MaxOutput: 2 << 30,
+}
+
+// withDefaults returns the sandbox with zero fields replaced by DefaultSandbox
+func (s Sandbox) withDefaults() Sandbox {
+	if s.Timeout == 0 {
+		s.Timeout = DefaultSandbox.Timeout
+	}
+	if s.CPUTime == 0 {
+		s.CPUTime = DefaultSandbox.CPUTime
+	}
+	if s.MaxMemory == 0 {
+		s.MaxMemory = DefaultSandbox.MaxMemory
+	}
+	if s.MaxOutput == 0 {
+		s.MaxOutput = DefaultSandbox.MaxOutput
+	}
+	return s
+}
+
+// runFFmpeg runs ffmpeg inside the sandbox. Every ffmpeg call of the package goes through it:
+// the demuxer and the protocol whitelist are added before each input, the rlimits are applied
+// to the process and the run is canceled when the timeout expires.
+func runFFmpeg(ctx context.Context, sandbox Sandbox, demuxer string, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	sandbox = sandbox.withDefaults()
+	if sandbox.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sandbox.Timeout)
+		defer cancel()
+	}
+
+	var output *limitedWriter
+	if stdout != nil && sandbox.MaxOutput > 0 {
+		output = &limitedWriter{w: stdout, max: sandbox.MaxOutput}
+		stdout = output
+	}
+
+	err := runCommand(ctx, "ffmpeg", sandboxArgs(demuxer, args), stdin, stdout, stderr, func(pid int) error {
+		return limitProcess(pid, sandbox)
+	})
+	if output != nil && output.exceeded {
+		return fmt.Errorf("%w: ffmpeg output is larger than %d bytes", ErrLimitExceeded, sandbox.MaxOutput)
+	}
+	return err
+}
+
+// sandboxArgs adds the protocol whitelist and the demuxer before every input of the ffmpeg arguments
+func sandboxArgs(demuxer string, args []string) []string {
+	sandboxed := make([]string, 0, len(args)+6)
+	sandboxed = append(sandboxed, "-nostdin")
+	for i, arg := range args {
+		if arg == "-i" && i+1 < len(args) {
+			sandboxed = append(sandboxed, "-protocol_whitelist", allowedProtocols)
+			if demuxer != "" {
+				sandboxed = append(sandboxed, "-f", demuxer)
+			}
+		}
+		sandboxed = append(sandboxed, arg)
+	}
+	return sandboxed
+}
+
+// limitedWriter fails once more than max bytes are written, ffmpeg then stops on a broken pipe
+type limitedWriter struct {
+	w        io.Writer
+	written  int64
+	max      int64
+	exceeded bool
+}
+
+// Write writes p to the underlying writer unless the limit is exceeded
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if lw.written+int64(len(p)) > lw.max {
+		lw.exceeded = true
+		return 0, ErrLimitExceeded
+	}
+	n, err := lw.w.Write(p)
+	lw.written += int64(n)
+	return n, err
+}
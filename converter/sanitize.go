@@ -0,0 +1,135 @@
+package converter
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxFileNameLength is the maximum length in bytes of a sanitized file name
+const maxFileNameLength = 200
+
+// NameStrategy defines how the default storage key of a converted file is built
+type NameStrategy int
+
+const (
+	NameOriginal    NameStrategy = iota // Sanitized original name, for example "processed_photo.webp"
+	NameUUID                            // Random UUID, for example "0b8a6c1e-....webp"
+	NameContentHash                     // SHA-256 of the converted file, for example "9f86d0....webp"
+)
+
+// SanitizeFileName returns a file name that is safe to use inside a storage directory.
+// It strips path components, normalizes unicode to NFC, removes control and reserved
+// characters and limits the length keeping the extension. It returns "" when nothing is left.
+func SanitizeFileName(name string) string {
+	// Strip path components, both Unix and Windows separators
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = norm.NFC.String(name)
+
+	name = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return -1
+		}
+		return r
+	}, name)
+
+	// Leading dots would make hidden files, "." and ".." are directories
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	name = strings.TrimRight(name, ". ")
+
+	if len(name) > maxFileNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > maxFileNameLength/2 {
+			ext = ""
+		}
+		name = truncateUTF8(strings.TrimSuffix(name, ext), maxFileNameLength-len(ext)) + ext
+	}
+	return name
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// validateFileName returns ErrInvalidOption when the file name is empty after sanitization
+func validateFileName(fileName string) error {
+	if SanitizeFileName(fileName) == "" {
+		return fmt.Errorf("%w: invalid file name: %q", ErrInvalidOption, fileName)
+	}
+	return nil
+}
+
+// validateKey returns ErrInvalidOption when the key is absolute or escapes the storage root
+func validateKey(key string) error {
+	cleaned := path.Clean(strings.ReplaceAll(key, "\\", "/"))
+	if key == "" || path.IsAbs(cleaned) || filepath.IsAbs(key) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return fmt.Errorf("%w: invalid storage key: %q", ErrInvalidOption, key)
+	}
+	return nil
+}
+
+// defaultKey builds the storage key of a converted file with the naming strategy.
+// The prefix is only used by NameOriginal.
+func defaultKey(strategy NameStrategy, prefix, fileName, format string, result *Result) (string, error) {
+	switch strategy {
+	case NameOriginal:
+		name := SanitizeFileName(fileName)
+		return prefix + strings.TrimSuffix(name, filepath.Ext(name)) + "." + format, nil
+	case NameUUID:
+		return uuid.NewString() + "." + format, nil
+	case NameContentHash:
+		return result.SHA256 + "." + format, nil
+	default:
+		return "", fmt.Errorf("%w: unknown name strategy: %d", ErrInvalidOption, strategy)
+	}
+}
+
+// resultKey returns the explicit key when it is set, otherwise the default key of the strategy
+func resultKey(key string, strategy NameStrategy, prefix, fileName, format string, result *Result) (string, error) {
+	if key != "" {
+		return key, validateKey(key)
+	}
+	return defaultKey(strategy, prefix, fileName, format, result)
+}
+
+// storedKey returns the key Convert stored the file under when the result is not available.
+// It fails for NameUUID and NameContentHash without an explicit key, their keys are only known from the Result.
+func storedKey(key string, strategy NameStrategy, prefix, fileName, format string) (string, error) {
+	if key != "" {
+		return key, validateKey(key)
+	}
+	if strategy != NameOriginal {
+		return "", fmt.Errorf("%w: the key of name strategy %d is only known from the Result, set Key or pass Result.Key", ErrInvalidOption, strategy)
+	}
+	if format == "" {
+		return "", fmt.Errorf("%w: format to convert is required to find the converted file", ErrInvalidOption)
+	}
+	return defaultKey(strategy, prefix, fileName, format, nil)
+}
+
+// withinDir reports whether target is a path inside dir
+func withinDir(dir, target string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absTarget)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
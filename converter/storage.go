@@ -0,0 +1,363 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Storage stores converted files by key
+type Storage interface {
+	// Put stores the content under key and returns its location (a path or a URL)
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error)
+	// Get opens the content stored under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the content stored under key
+	Delete(ctx context.Context, key string) error
+}
+
+// ---------------------------------------------------------------------
+// ------------------------------ LOCAL --------------------------------
+// ---------------------------------------------------------------------
+
+// LocalStorage stores files in a directory of the local filesystem
+type LocalStorage struct {
+	Dir string // Directory to store the files
+}
+
+// NewLocalStorage creates a storage in the directory
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{Dir: dir}
+}
+
+// path returns the filesystem path of a key, keys that escape Dir are rejected
+func (s *LocalStorage) path(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the content to a temporary file and renames it, so readers never see a partial file
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	dest, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", fmt.Errorf("failed to create storage dir: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to move file: %w", err)
+	}
+	return dest, nil
+}
+
+// Get opens the file of the key
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filePath)
+}
+
+// Delete removes the file of the key
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filePath)
+}
+
+// ---------------------------------------------------------------------
+// ------------------------------ MEMORY -------------------------------
+// ---------------------------------------------------------------------
+
+// MemoryStorage keeps files in memory, it is meant for tests and short-lived results
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	files map[string]memoryFile
+}
+
+// memoryFile is a file stored in MemoryStorage
+type memoryFile struct {
+	data        []byte
+	contentType string
+}
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string]memoryFile)}
+}
+
+// Put stores a copy of the content, the location has the form "mem://key"
+func (s *MemoryStorage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read content: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[key] = memoryFile{data: data, contentType: contentType}
+	return "mem://" + key, nil
+}
+
+// Get returns a reader over the stored content
+func (s *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	file, ok := s.files[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(file.data)), nil
+}
+
+// Delete removes the stored content
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[key]; !ok {
+		return fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	delete(s.files, key)
+	return nil
+}
+
+// Bytes returns the stored content and its content type
+func (s *MemoryStorage) Bytes(key string) ([]byte, string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	file, ok := s.files[key]
+	return file.data, file.contentType, ok
+}
+
+// ---------------------------------------------------------------------
+// -------------------------------- S3 ---------------------------------
+// ---------------------------------------------------------------------
+
+// S3Config holds configuration for S3-compatible storage
+type S3Config struct {
+	Endpoint        string // Endpoint of the S3-compatible storage, for example "http://localhost:9000" for MinIO. Empty for AWS S3
+	Region          string // Region of the bucket
+	Bucket          string // Name of the bucket
+	Prefix          string // Prefix added to every key
+	AccessKeyID     string // Access key. Empty to use the default AWS credential chain
+	SecretAccessKey string // Secret key
+	ForcePathStyle  bool   // Use path-style URLs (required by MinIO)
+}
+
+// S3Storage stores files in an S3-compatible bucket
+type S3Storage struct {
+	bucket   string
+	prefix   string
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// NewS3Storage creates a storage in an S3-compatible bucket
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("%w: bucket is required", ErrInvalidOption)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("%w: region is required", ErrInvalidOption)
+	}
+
+	awsConfig := &aws.Config{
+		Region:           aws.String(cfg.Region),
+		S3ForcePathStyle: aws.Bool(cfg.ForcePathStyle),
+	}
+	if cfg.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(cfg.Endpoint)
+	}
+	if cfg.AccessKeyID != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	}
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 session: %w", err)
+	}
+
+	return &S3Storage{
+		bucket:   cfg.Bucket,
+		prefix:   strings.Trim(cfg.Prefix, "/"),
+		client:   s3.New(sess),
+		uploader: s3manager.NewUploader(sess),
+	}, nil
+}
+
+// objectKey returns the object key of a storage key
+func (s *S3Storage) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return path.Join(s.prefix, key)
+}
+
+// Put uploads the content, the location has the form "s3://bucket/key"
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, contentType string) (string, error) {
+	objectKey := s.objectKey(key)
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectKey),
+		Body:   r,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	if _, err := s.uploader.UploadWithContext(ctx, input); err != nil {
+		return "", fmt.Errorf("failed to upload %s: %w", objectKey, err)
+	}
+	return "s3://" + s.bucket + "/" + objectKey, nil
+}
+
+// Get downloads the object of the key
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	output, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+		}
+		return nil, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	return output.Body, nil
+}
+
+// Delete removes the object of the key
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+// ----------------------------- HELPERS -------------------------------
+// ---------------------------------------------------------------------
+
+// resolveStorage returns the configured storage, or a local storage in dir when it is nil
+func resolveStorage(storage Storage, dir string) Storage {
+	if storage != nil {
+		return storage
+	}
+	return NewLocalStorage(dir)
+}
+
+// deleteStored removes reqFilePath when it is given, otherwise the key returned by key.
+// With a Storage the path is a key of that storage, such as Result.Key, and URLs like "s3://bucket/x" are rejected;
+// without one it is a local path that must be inside dir, or inside the working directory when dir is empty.
+func deleteStored(storage Storage, dir string, key func() (string, error), reqFilePath []string) error {
+	if len(reqFilePath) == 0 {
+		target, err := key()
+		if err != nil {
+			return err
+		}
+		return resolveStorage(storage, dir).Delete(context.Background(), target)
+	}
+	target := reqFilePath[0]
+	if strings.Contains(target, "://") {
+		return fmt.Errorf("%w: expected a storage key, not a URL: %s", ErrInvalidOption, target)
+	}
+	if storage != nil {
+		if err := validateKey(target); err != nil {
+			return err
+		}
+		return storage.Delete(context.Background(), target)
+	}
+	if dir == "" {
+		dir = "."
+	}
+	if !withinDir(dir, target) {
+		return fmt.Errorf("%w: path is outside the storage dir: %s", ErrInvalidOption, target)
+	}
+	return os.Remove(target)
+}
+
+// stageOriginal copies the uploaded file into a new temporary directory.
+// The caller must remove the returned directory.
+func stageOriginal(file io.Reader, fileName string) (string, string, error) {
+	workDir, err := os.MkdirTemp("", "fastgo-*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp dir: %w", err)
+	}
+
+	tempPath := filepath.Join(workDir, SanitizeFileName(fileName))
+	outFile, err := os.Create(tempPath)
+	if err != nil {
+		os.RemoveAll(workDir)
+		return "", "", fmt.Errorf("failed to save original: %w", err)
+	}
+	if _, err := io.Copy(outFile, file); err != nil {
+		outFile.Close()
+		os.RemoveAll(workDir)
+		return "", "", fmt.Errorf("failed to write original: %w", err)
+	}
+	if err := outFile.Close(); err != nil {
+		os.RemoveAll(workDir)
+		return "", "", fmt.Errorf("failed to write original: %w", err)
+	}
+	return workDir, tempPath, nil
+}
+
+// storeResult puts the local output file into the storage and updates the result location
+func storeResult(ctx context.Context, storage Storage, key, localPath string, result *Result) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open converted file: %w", err)
+	}
+	defer file.Close()
+	return putResult(ctx, storage, key, file, result)
+}
+
+// putResult puts the converted content into the storage and updates the result location
+func putResult(ctx context.Context, storage Storage, key string, r io.Reader, result *Result) error {
+	location, err := storage.Put(ctx, key, r, result.MIMEType)
+	if err != nil {
+		return contextError(ctx, fmt.Errorf("failed to store converted file: %w", err))
+	}
+	result.Path = location
+	result.Key = key
+	return nil
+}
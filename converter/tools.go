@@ -0,0 +1,283 @@
+package converter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requiredEncoders are the ffmpeg encoders used by the pipelines
+var requiredEncoders = []string{"libx264", "libvpx", "libvorbis", "libopus", "libmp3lame", "aac", "pcm_s16le"}
+
+// formatTools maps an output format to the tool and the encoders that produce it.
+// Formats missing from the map are encoded in Go.
+var formatTools = map[FileType]map[string][]string{
+	Image: {
+		WEBP: {"cwebp"},
+	},
+	Video: {
+		MP4:  {"ffmpeg", "libx264"},
+		WEBM: {"ffmpeg", "libvpx", "libvorbis"},
+	},
+	Audio: {
+		MP3:  {"ffmpeg", "libmp3lame"},
+		M4A:  {"ffmpeg", "aac"},
+		OPUS: {"ffmpeg", "libopus"},
+		WAV:  {"ffmpeg", "pcm_s16le"},
+	},
+}
+
+// ToolSet resolves the external tools used by the converter and checks their capabilities
+type ToolSet struct {
+	FFmpeg string // Path or name of ffmpeg. Default: "ffmpeg" from PATH
+	CWebP  string // Path or name of cwebp. Default: "cwebp" from PATH
+
+	mu     sync.Mutex
+	report *HealthReport // last report, nil until the first check
+	check  sync.Mutex    // serializes the checks run by the pipelines
+}
+
+// Lifetimes of the report used by the pipelines. A failed check is repeated sooner, so a tool
+// installed after the start or a transient failure of the check does not need a restart.
+const (
+	healthyReportTTL   = 10 * time.Minute
+	unhealthyReportTTL = 30 * time.Second
+)
+
+// ToolStatus describes an external tool in a HealthReport
+type ToolStatus struct {
+	Name     string   `json:"name"`               // Name of the tool, "ffmpeg" or "cwebp"
+	Path     string   `json:"path,omitempty"`     // Resolved path of the binary
+	Version  string   `json:"version,omitempty"`  // Version reported by the tool
+	Encoders []string `json:"encoders,omitempty"` // Required encoders that are available
+	Missing  []string `json:"missing,omitempty"`  // Required encoders that are not available
+	Error    string   `json:"error,omitempty"`    // Error when the tool could not be found or run
+}
+
+// HealthReport is the result of ToolSet.HealthCheck
+type HealthReport struct {
+	Healthy   bool         `json:"healthy"`   // True when every tool was found and no encoder is missing
+	Tools     []ToolStatus `json:"tools"`     // Status of each tool
+	CheckedAt time.Time    `json:"checkedAt"` // Time of the check
+}
+
+var (
+	toolsMu sync.RWMutex
+	tools   = NewToolSet("", "")
+)
+
+// NewToolSet creates a tool set, empty paths use the binaries from PATH
+func NewToolSet(ffmpeg, cwebp string) *ToolSet {
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	if cwebp == "" {
+		cwebp = "cwebp"
+	}
+	return &ToolSet{FFmpeg: ffmpeg, CWebP: cwebp}
+}
+
+// SetToolSet replaces the tool set used by every pipeline
+func SetToolSet(toolSet *ToolSet) {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	tools = toolSet
+}
+
+// Tools returns the tool set used by every pipeline
+func Tools() *ToolSet {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	return tools
+}
+
+// HealthCheck checks the tool set used by every pipeline
+func HealthCheck() HealthReport {
+	return Tools().HealthCheck()
+}
+
+// HealthCheck resolves the tools, reads their versions and the available ffmpeg encoders.
+// The report is cached and used by the pipelines to fail fast.
+func (t *ToolSet) HealthCheck() HealthReport {
+	return t.HealthCheckContext(context.Background())
+}
+
+// HealthCheckContext is HealthCheck with a context
+func (t *ToolSet) HealthCheckContext(ctx context.Context) HealthReport {
+	report := HealthReport{
+		Healthy:   true,
+		Tools:     []ToolStatus{t.checkFFmpeg(ctx), t.checkCWebP(ctx)},
+		CheckedAt: time.Now(),
+	}
+	for _, status := range report.Tools {
+		if status.Error != "" || len(status.Missing) > 0 {
+			report.Healthy = false
+		}
+	}
+
+	// A report of a canceled check is not reliable, it is not cached
+	if ctx.Err() == nil {
+		t.mu.Lock()
+		t.report = &report
+		t.mu.Unlock()
+	}
+	return report
+}
+
+// path returns the configured path of a tool
+func (t *ToolSet) path(tool string) string {
+	switch tool {
+	case "ffmpeg":
+		return t.FFmpeg
+	case "cwebp":
+		return t.CWebP
+	}
+	return tool
+}
+
+// cachedReport returns the last report, or nil when there is none or it expired
+func (t *ToolSet) cachedReport() *HealthReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.report == nil {
+		return nil
+	}
+	ttl := healthyReportTTL
+	if !t.report.Healthy {
+		ttl = unhealthyReportTTL
+	}
+	if time.Since(t.report.CheckedAt) > ttl {
+		return nil
+	}
+	return t.report
+}
+
+// require returns ErrToolMissing when the tool or one of the encoders is not available.
+// The tools are checked the first time a pipeline needs them and again when the report expired.
+func (t *ToolSet) require(ctx context.Context, tool string, encoders ...string) error {
+	report := t.cachedReport()
+	if report == nil {
+		t.check.Lock()
+		// Another pipeline may have checked while this one waited
+		if report = t.cachedReport(); report == nil {
+			checked := t.HealthCheckContext(ctx)
+			report = &checked
+		}
+		t.check.Unlock()
+		if err := ctx.Err(); err != nil {
+			return contextError(ctx, err)
+		}
+	}
+
+	for _, status := range report.Tools {
+		if status.Name != tool {
+			continue
+		}
+		if status.Error != "" {
+			return fmt.Errorf("%w: %s: %s", ErrToolMissing, tool, status.Error)
+		}
+		for _, encoder := range encoders {
+			if contains(status.Missing, encoder) {
+				return fmt.Errorf("%w: %s has no %s encoder", ErrToolMissing, tool, encoder)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrToolMissing, tool)
+}
+
+// checkFFmpeg resolves ffmpeg, reads its version and the required encoders
+func (t *ToolSet) checkFFmpeg(ctx context.Context) ToolStatus {
+	status := ToolStatus{Name: "ffmpeg"}
+	path, err := exec.LookPath(t.FFmpeg)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Path = path
+
+	// First line: "ffmpeg version 6.1.1 Copyright ..."
+	output, err := toolOutput(ctx, path, "-hide_banner", "-version")
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	if fields := strings.Fields(firstLine(output)); len(fields) >= 3 && fields[1] == "version" {
+		status.Version = fields[2]
+	}
+
+	// Encoder lines: " V....D libx264              libx264 H.264 / AVC ..."
+	output, err = toolOutput(ctx, path, "-hide_banner", "-encoders")
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	available := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 2 && len(fields[0]) == 6 {
+			available[fields[1]] = true
+		}
+	}
+	for _, encoder := range requiredEncoders {
+		if available[encoder] {
+			status.Encoders = append(status.Encoders, encoder)
+		} else {
+			status.Missing = append(status.Missing, encoder)
+		}
+	}
+	return status
+}
+
+// checkCWebP resolves cwebp and reads its version
+func (t *ToolSet) checkCWebP(ctx context.Context) ToolStatus {
+	status := ToolStatus{Name: "cwebp"}
+	path, err := exec.LookPath(t.CWebP)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Path = path
+
+	// Output: "1.3.2" or "WebP Encoder version: 1.3.2" depending on the build
+	output, err := toolOutput(ctx, path, "-version")
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	if fields := strings.Fields(firstLine(output)); len(fields) > 0 {
+		status.Version = fields[len(fields)-1]
+	}
+	return status
+}
+
+// toolOutput runs a tool with a short timeout and returns its standard output
+func toolOutput(ctx context.Context, path string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", path, strings.Join(args, " "), err)
+	}
+	return output, nil
+}
+
+// firstLine returns the first line of the output
+func firstLine(output []byte) string {
+	line, _, _ := bytes.Cut(output, []byte("\n"))
+	return strings.TrimSpace(string(line))
+}
+
+// requireFormat returns ErrToolMissing when the tool or an encoder needed for the output format is not available
+func requireFormat(ctx context.Context, fileType FileType, format string) error {
+	needed, ok := formatTools[fileType][format]
+	if !ok {
+		return nil
+	}
+	return Tools().require(ctx, needed[0], needed[1:]...)
+}
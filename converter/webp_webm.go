@@ -2,38 +2,31 @@ package converter
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/png"
-	"os/exec"
+	"io"
 
 	_ "golang.org/x/image/webp"
 )
 
-// convertToWebp converts an image to WebP format
-func convertToWebp(img any, quality uint8) ([]byte, error) {
-	cmd := exec.Command("cwebp", "-q", fmt.Sprint(quality), "-o", "-", "--", "-")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, err
-	}
-	if err := cmd.Start(); err != nil {
-		return nil, err
-	}
+// encodeWebp streams an image through cwebp and writes the WebP output to w
+func encodeWebp(ctx context.Context, w io.Writer, img image.Image, quality uint8) error {
+	args := []string{"-q", fmt.Sprint(quality), "-o", "-", "--", "-"}
+	stdin, stdinWriter := io.Pipe()
 	go func() {
-		defer stdin.Close()
-		_ = png.Encode(stdin, img.(image.Image))
+		stdinWriter.CloseWithError(png.Encode(stdinWriter, img))
 	}()
-	if err := cmd.Wait(); err != nil {
-		return nil, err
-	}
-	return out.Bytes(), nil
+	defer stdin.Close()
+
+	return runTool(ctx, "cwebp", args, stdin, w, nil)
 }
 
-// convertToWebm converts a video to WebM format with specified dimensions and quality
-func convertToWebm(inputVideoPath string, quality int, width int, height int) ([]byte, error) {
+// convertToWebm converts a video to WebM format with specified dimensions and quality.
+// The input is read with the demuxer, the ffmpeg log is written to stderr and ffmpeg runs in the sandbox,
+// it is killed when ctx is done.
+func convertToWebm(ctx context.Context, sandbox Sandbox, demuxer, inputVideoPath string, quality int, width int, height int, stderr io.Writer) ([]byte, error) {
 	var crf int
 	var maxrate string
 
@@ -59,13 +52,9 @@ func convertToWebm(inputVideoPath string, quality int, width int, height int) ([
 		maxrate = "1M"
 	}
 
-	cmd := exec.Command("ffmpeg", "-i", inputVideoPath, "-vf", fmt.Sprintf("scale=%d:%d", width, height), "-c:v", "libvpx", "-crf", fmt.Sprint(crf), "-b:v", maxrate, "-c:a", "libvorbis", "-f", "webm", "-")
+	args := []string{"-i", inputVideoPath, "-vf", fmt.Sprintf("scale=%d:%d", width, height), "-c:v", "libvpx", "-crf", fmt.Sprint(crf), "-b:v", maxrate, "-c:a", "libvorbis", "-f", "webm", "-"}
 	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Start(); err != nil {
-		return nil, err
-	}
-	if err := cmd.Wait(); err != nil {
+	if err := runFFmpeg(ctx, sandbox, demuxer, args, nil, &out, stderr); err != nil {
 		return nil, err
 	}
 	return out.Bytes(), nil
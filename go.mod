@@ -3,18 +3,22 @@ module github.com/raulbondarchuk/fast-go
 go 1.22.4
 
 require (
+	github.com/aws/aws-sdk-go v1.38.20
 	github.com/disintegration/imaging v1.6.2
+	github.com/google/uuid v1.6.0
 	github.com/tidwall/sjson v1.2.5
 	github.com/u2takey/ffmpeg-go v0.5.0
+	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
 	golang.org/x/mod v0.22.0
+	golang.org/x/sys v0.30.0
+	golang.org/x/text v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/aws/aws-sdk-go v1.38.20 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 	github.com/tidwall/gjson v1.14.2 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
 	github.com/u2takey/go-utils v0.3.1 // indirect
-	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
 )
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/raulbondarchuk/fast-go/converter"
+)
+
+// Errors of the server that have no converter sentinel
+var (
+	errNotFound  = errors.New("not found")           // the preset or the source image does not exist
+	errNotReady  = errors.New("result is not ready") // the job is not done
+	errSignature = errors.New("invalid signature")   // the signature of a transform URL is wrong
+)
+
+// errorResponse is the body of an error response
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// statusOf returns the HTTP status of an error
+func statusOf(err error) int {
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytes), errors.Is(err, converter.ErrLimitExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, converter.ErrUnsupportedFormat), errors.Is(err, converter.ErrContentMismatch):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, converter.ErrInvalidOption), errors.Is(err, converter.ErrInvalidDimensions):
+		return http.StatusBadRequest
+	case errors.Is(err, converter.ErrDecode):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errSignature):
+		return http.StatusForbidden
+	case errors.Is(err, converter.ErrJobNotFound), errors.Is(err, errNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errNotReady):
+		return http.StatusConflict
+	case errors.Is(err, converter.ErrToolMissing), errors.Is(err, converter.ErrQueueClosed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, converter.ErrCanceled):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError writes the error as JSON with its status, server errors are logged
+func writeError(w http.ResponseWriter, err error) {
+	status := statusOf(err)
+	if status >= http.StatusInternalServerError {
+		log.Printf("server: %v", err)
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// writeJSON writes the value as JSON with the status
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("server: failed to write response: %v", err)
+	}
+}
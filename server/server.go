@@ -0,0 +1,399 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/raulbondarchuk/fast-go/converter"
+)
+
+// Example of use
+/*
+func main() {
+	storage := converter.NewLocalStorage("./data/files")
+	queue, err := converter.NewQueue(converter.QueueConfig{Dir: "./data/jobs", Storage: storage})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer queue.Close()
+
+	srv, err := server.New(server.Config{Queue: queue, Storage: storage})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(http.ListenAndServe(":8080", srv))
+}
+*/
+
+// kindTypes maps the kind of the settings to the media type of the upload
+var kindTypes = map[converter.PresetKind]converter.FileType{
+	converter.PresetImage: converter.Image,
+	converter.PresetLogo:  converter.Image,
+	converter.PresetVideo: converter.Video,
+	converter.PresetAudio: converter.Audio,
+}
+
+// Config holds configuration for a Server
+type Config struct {
+	Queue          *converter.Queue             // Queue of the asynchronous conversions. Required
+	Storage        converter.Storage            // Storage of the queue, the results of jobs are downloaded from it. Required
+	MaxUploadBytes map[converter.FileType]int64 // Maximum request body per media type. Default: image 50 MB, video 1 GB, audio 200 MB
+	Limits         converter.Limits             // Limits for source images
+	Cache          *converter.Cache             // Cache of the synchronous conversions, usually the cache of the queue. Nil disables caching
+	Timeout        time.Duration                // Timeout of a synchronous image conversion. Default: 1 minute
+}
+
+// Server exposes the converter over HTTP.
+//
+//	POST /v1/convert/{kind}     convert an upload with the settings of the query, kind is image, logo, video or audio
+//	POST /v1/presets/{name}     convert an upload with a registered preset, the query overrides its settings
+//	GET  /v1/presets            list the registered presets
+//	GET  /v1/jobs/{id}          state of a job
+//	GET  /v1/jobs/{id}/events   state of a job on every change, as server-sent events
+//	GET  /v1/jobs/{id}/result   converted file of a done job
+//	GET  /healthz               health report of the external tools
+//
+// The file is read from the "file" field of a multipart body. Images and logos are converted
+// while the request is served and the output is streamed in the response; videos, audio
+// and uploads with async=true are queued and answered with 202 and the job state.
+type Server struct {
+	config Config
+	mux    *http.ServeMux
+}
+
+// New creates a server
+func New(config Config) (*Server, error) {
+	if config.Queue == nil {
+		return nil, fmt.Errorf("%w: queue is required", converter.ErrInvalidOption)
+	}
+	if config.Storage == nil {
+		return nil, fmt.Errorf("%w: storage is required", converter.ErrInvalidOption)
+	}
+	maxUploadBytes := map[converter.FileType]int64{
+		converter.Image: converter.DefaultLimits.MaxInputBytes,
+		converter.Video: 1 << 30,
+		converter.Audio: 200 << 20,
+	}
+	for fileType, n := range config.MaxUploadBytes {
+		maxUploadBytes[fileType] = n
+	}
+	config.MaxUploadBytes = maxUploadBytes
+	if config.Timeout <= 0 {
+		config.Timeout = time.Minute
+	}
+
+	s := &Server{config: config, mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /v1/convert/{kind}", s.handleConvert)
+	s.mux.HandleFunc("POST /v1/presets/{name}", s.handlePreset)
+	s.mux.HandleFunc("GET /v1/presets", s.handlePresets)
+	s.mux.HandleFunc("GET /v1/jobs/{id}", s.handleJob)
+	s.mux.HandleFunc("GET /v1/jobs/{id}/events", s.handleJobEvents)
+	s.mux.HandleFunc("GET /v1/jobs/{id}/result", s.handleJobResult)
+	s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	return s, nil
+}
+
+// ServeHTTP dispatches the request to the handler of its route
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handleConvert converts an upload with the settings of the query
+func (s *Server) handleConvert(w http.ResponseWriter, r *http.Request) {
+	kind := converter.PresetKind(r.PathValue("kind"))
+	if _, ok := kindTypes[kind]; !ok {
+		writeError(w, fmt.Errorf("%w: unknown kind %q", converter.ErrInvalidOption, kind))
+		return
+	}
+	overrides, err := overridesFromQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	settings, err := converter.Preset{Name: string(kind), Kind: kind}.With(overrides)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.convert(w, r, converter.Task{Settings: settings}, settings)
+}
+
+// handlePreset converts an upload with a registered preset
+func (s *Server) handlePreset(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	preset, ok := converter.GetPreset(name)
+	if !ok {
+		writeError(w, fmt.Errorf("%w: unknown preset %q", errNotFound, name))
+		return
+	}
+	overrides, err := overridesFromQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	settings, err := preset.With(overrides)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.convert(w, r, converter.Task{Preset: name, Overrides: &overrides}, settings)
+}
+
+// convert checks the upload against the media type of the settings and converts or queues it
+func (s *Server) convert(w http.ResponseWriter, r *http.Request, task converter.Task, settings converter.Preset) {
+	fileType := kindTypes[settings.Kind]
+	if max := s.config.MaxUploadBytes[fileType]; max > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+	}
+
+	fileName, file, err := uploadedFile(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if converter.DetermineFileType(fileName) != fileType {
+		writeError(w, fmt.Errorf("%w: %s can not be converted with %s settings", converter.ErrUnsupportedFormat, fileName, settings.Kind))
+		return
+	}
+	if _, file, err = converter.SniffFileType(fileName, file); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if fileType != converter.Image || r.URL.Query().Get("async") == "true" {
+		task.FileName = fileName
+		task.NameStrategy = converter.NameUUID
+		s.submit(w, task, file)
+		return
+	}
+	s.stream(w, r, settings, fileName, file)
+}
+
+// submit queues the conversion and answers with the state of the job
+func (s *Server) submit(w http.ResponseWriter, task converter.Task, file io.Reader) {
+	id, err := s.config.Queue.Submit(task, file)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	state, err := s.config.Queue.Status(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/v1/jobs/"+url.PathEscape(id))
+	writeJSON(w, http.StatusAccepted, state)
+}
+
+// stream converts an image or a logo and writes the output to the response
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, settings converter.Preset, fileName string, file io.Reader) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.Timeout)
+	defer cancel()
+
+	out := &responseWriter{ResponseWriter: w}
+	w.Header().Set("Content-Type", converter.MIMEType(settings.FormatToConvert))
+	var err error
+	switch settings.Kind {
+	case converter.PresetImage:
+		var config *converter.ImageConfig
+		if config, err = settings.ImageConfig(fileName, file); err == nil {
+			config.Limits, config.Cache = s.config.Limits, s.config.Cache
+			_, err = config.ConvertToContext(ctx, out)
+		}
+	case converter.PresetLogo:
+		var config *converter.LogoConfig
+		if config, err = settings.LogoConfig(fileName, file); err == nil {
+			config.Limits, config.Cache = s.config.Limits, s.config.Cache
+			_, err = config.ConvertToContext(ctx, out)
+		}
+	}
+	if err == nil {
+		return
+	}
+	if out.written {
+		// The status is already sent, the client sees a truncated body
+		log.Printf("server: conversion of %s failed after the response started: %v", fileName, err)
+		return
+	}
+	writeError(w, err)
+}
+
+// handlePresets lists the registered presets
+func (s *Server) handlePresets(w http.ResponseWriter, r *http.Request) {
+	list := make([]converter.Preset, 0)
+	for _, name := range converter.PresetNames() {
+		if preset, ok := converter.GetPreset(name); ok {
+			list = append(list, preset)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleJob returns the state of a job
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	state, err := s.config.Queue.Status(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+// handleJobEvents sends the state of a job on every change until it is finished
+func (s *Server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	updates, stop, err := s.config.Queue.Subscribe(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	for {
+		select {
+		case state, ok := <-updates:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(state)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", state.Status, data); err != nil {
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleJobResult streams the converted file of a done job from the storage
+func (s *Server) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	state, err := s.config.Queue.Status(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if state.Status != converter.JobDone || state.Result == nil {
+		writeError(w, fmt.Errorf("%w: job is %s", errNotReady, state.Status))
+		return
+	}
+
+	file, err := s.config.Storage.Get(r.Context(), state.Result.Key)
+	if err != nil {
+		writeError(w, fmt.Errorf("failed to open result: %w", err))
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", state.Result.MIMEType)
+	w.Header().Set("Content-Length", strconv.FormatInt(state.Result.Size, 10))
+	w.Header().Set("ETag", strconv.Quote(state.Result.SHA256))
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("server: download of job %s failed: %v", state.ID, err)
+	}
+}
+
+// handleHealth returns the health report of the external tools
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	report := converter.Tools().HealthCheckContext(r.Context())
+	status := http.StatusOK
+	if !report.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// uploadedFile returns the name and the content of the "file" field of a multipart body.
+// The part is read while it is converted, the body is not buffered.
+func uploadedFile(r *http.Request) (string, io.Reader, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: multipart body is required: %w", converter.ErrInvalidOption, err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return "", nil, fmt.Errorf("%w: file field is required", converter.ErrInvalidOption)
+		}
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			return "", nil, fmt.Errorf("%w: upload is larger than %d bytes", converter.ErrLimitExceeded, maxBytes.Limit)
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: invalid multipart body: %w", converter.ErrInvalidOption, err)
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			return part.FileName(), part, nil
+		}
+	}
+}
+
+// overridesFromQuery reads the conversion settings set in the query
+func overridesFromQuery(query url.Values) (converter.PresetOverrides, error) {
+	var overrides converter.PresetOverrides
+	if format := query.Get("format"); format != "" {
+		overrides.FormatToConvert = &format
+	}
+	ints := map[string]**int{
+		"width":     &overrides.Width,
+		"height":    &overrides.Height,
+		"quality":   &overrides.Quality,
+		"bitrate":   &overrides.Bitrate,
+		"maxWidth":  &overrides.MaxWidth,
+		"maxHeight": &overrides.MaxHeight,
+		"minWidth":  &overrides.MinWidth,
+		"minHeight": &overrides.MinHeight,
+	}
+	for name, field := range ints {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return overrides, fmt.Errorf("%w: %s must be an integer", converter.ErrInvalidOption, name)
+			}
+			*field = &n
+		}
+	}
+	if value := query.Get("stretchThreshold"); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return overrides, fmt.Errorf("%w: stretchThreshold must be a number", converter.ErrInvalidOption)
+		}
+		overrides.StretchThreshold = &threshold
+	}
+	if value := query.Get("transparentBackground"); value != "" {
+		transparent, err := strconv.ParseBool(value)
+		if err != nil {
+			return overrides, fmt.Errorf("%w: transparentBackground must be true or false", converter.ErrInvalidOption)
+		}
+		overrides.TransparentBackground = &transparent
+	}
+	return overrides, nil
+}
+
+// responseWriter records whether the response has started
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+// Write writes p to the response
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.written = true
+	return rw.ResponseWriter.Write(p)
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raulbondarchuk/fast-go/converter"
+)
+
+// fits maps the fit option of a transform URL to the fit of ImageConfig
+var fits = map[string]converter.Fit{
+	"contain": converter.FitContain,
+	"cover":   converter.FitCover,
+	"fill":    converter.FitFill,
+}
+
+// TransformConfig holds configuration for a TransformHandler
+type TransformConfig struct {
+	Secret      []byte            // HMAC key of the URL signatures. Required
+	Source      converter.Storage // Storage of the source images. Required
+	Cache       converter.Storage // Storage of the transformed images, nil disables caching
+	CachePrefix string            // Prefix of the cache keys. Default: "transforms/"
+	Limits      converter.Limits  // Limits for source images
+	Timeout     time.Duration     // Timeout of a transformation. Default: 30 seconds
+}
+
+// Transform are the options of a transform URL
+type Transform struct {
+	Width   int           // w: target width
+	Height  int           // h: target height
+	Fit     converter.Fit // fit: contain, cover or fill. Default: contain
+	Format  string        // f: output format. Default: webp
+	Quality int           // q: quality 1-5. Default: 4
+}
+
+// String returns the options in their canonical URL form, for example "w:400,h:300,fit:cover,f:webp,q:4"
+func (t Transform) String() string {
+	fit := "contain"
+	for name, value := range fits {
+		if value == t.Fit {
+			fit = name
+		}
+	}
+	return fmt.Sprintf("w:%d,h:%d,fit:%s,f:%s,q:%d", t.Width, t.Height, fit, t.Format, t.Quality)
+}
+
+// ParseTransform parses the options of a transform URL, for example "w:400,h:300,fit:cover,f:webp"
+func ParseTransform(options string) (Transform, error) {
+	transform := Transform{Format: converter.WEBP, Quality: 4}
+	for _, option := range strings.Split(options, ",") {
+		name, value, ok := strings.Cut(option, ":")
+		if !ok {
+			return transform, fmt.Errorf("%w: option %q must be name:value", converter.ErrInvalidOption, option)
+		}
+		var err error
+		switch name {
+		case "w":
+			transform.Width, err = strconv.Atoi(value)
+		case "h":
+			transform.Height, err = strconv.Atoi(value)
+		case "q":
+			transform.Quality, err = strconv.Atoi(value)
+		case "f":
+			transform.Format = strings.ToLower(value)
+		case "fit":
+			fit, ok := fits[value]
+			if !ok {
+				return transform, fmt.Errorf("%w: unknown fit %q", converter.ErrInvalidOption, value)
+			}
+			transform.Fit = fit
+		default:
+			return transform, fmt.Errorf("%w: unknown option %q", converter.ErrInvalidOption, name)
+		}
+		if err != nil {
+			return transform, fmt.Errorf("%w: %s must be an integer", converter.ErrInvalidOption, name)
+		}
+	}
+	return transform, nil
+}
+
+// SignTransform returns the signed URL path of a transformation of the source key,
+// for example "/t/<signature>/w:400,h:300,fit:cover,f:webp/avatars/42.jpg"
+func SignTransform(secret []byte, options, key string) string {
+	return "/t/" + signature(secret, options, key) + "/" + options + "/" + key
+}
+
+// signature returns the base64url encoded HMAC-SHA256 of the options and the source key
+func signature(secret []byte, options, key string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("/" + options + "/" + key))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TransformHandler resizes and converts stored images on demand.
+//
+//	GET /t/{signature}/{options}/{key}
+//
+// The signature is the HMAC of the options and the key, see SignTransform, so clients can only
+// request the transformations the application generated URLs for. The output is cached and
+// served with long-lived cache headers: the URL of a transformation never changes its output
+// as long as the source key is not overwritten.
+type TransformHandler struct {
+	config TransformConfig
+	mux    *http.ServeMux
+
+	mu      sync.Mutex
+	running map[string]*keyLock // locks of the cache keys being transformed
+}
+
+// keyLock serializes the transformations of a cache key. The lock is held while ch holds a value,
+// so waiters can give up when their request is done.
+type keyLock struct {
+	ch    chan struct{}
+	users int // requests holding or waiting for the lock
+}
+
+// NewTransformHandler creates a transform handler
+func NewTransformHandler(config TransformConfig) (*TransformHandler, error) {
+	if len(config.Secret) == 0 {
+		return nil, fmt.Errorf("%w: secret is required", converter.ErrInvalidOption)
+	}
+	if config.Source == nil {
+		return nil, fmt.Errorf("%w: source storage is required", converter.ErrInvalidOption)
+	}
+	if config.CachePrefix == "" {
+		config.CachePrefix = "transforms/"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	h := &TransformHandler{config: config, mux: http.NewServeMux(), running: make(map[string]*keyLock)}
+	h.mux.HandleFunc("GET /t/{signature}/{options}/{key...}", h.handleTransform)
+	return h, nil
+}
+
+// ServeHTTP dispatches the request to the transform route
+func (h *TransformHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// handleTransform checks the signature and serves the cached or transformed image
+func (h *TransformHandler) handleTransform(w http.ResponseWriter, r *http.Request) {
+	options, key := r.PathValue("options"), r.PathValue("key")
+	expected := signature(h.config.Secret, options, key)
+	if !hmac.Equal([]byte(r.PathValue("signature")), []byte(expected)) {
+		writeError(w, errSignature)
+		return
+	}
+	transform, err := ParseTransform(options)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Equivalent option strings share the cache entry
+	sum := sha256.Sum256([]byte(transform.String() + "/" + key))
+	cacheKey := h.config.CachePrefix + hex.EncodeToString(sum[:]) + "." + transform.Format
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.Timeout)
+	defer cancel()
+	output, cached, err := h.transform(ctx, transform, key, cacheKey)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", converter.MIMEType(transform.Format))
+	w.Header().Set("Content-Length", strconv.Itoa(len(output)))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Cache", "MISS")
+	if cached {
+		w.Header().Set("X-Cache", "HIT")
+	}
+	if _, err := w.Write(output); err != nil {
+		log.Printf("server: transform response of %s failed: %v", key, err)
+	}
+}
+
+// transform returns the cached output or transforms the source and caches it.
+// Concurrent requests of the same cache key wait for the first one instead of transforming again.
+func (h *TransformHandler) transform(ctx context.Context, transform Transform, key, cacheKey string) ([]byte, bool, error) {
+	unlock, err := h.lock(ctx, cacheKey)
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	if output, ok := h.cached(ctx, cacheKey); ok {
+		return output, true, nil
+	}
+
+	source, err := h.config.Source.Get(ctx, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: source %s: %w", errNotFound, key, err)
+	}
+	defer source.Close()
+
+	detection, file, err := converter.SniffFileType(path.Base(key), source)
+	if err != nil {
+		return nil, false, err
+	}
+	if detection.FileType != converter.Image {
+		return nil, false, fmt.Errorf("%w: source %s is not an image", converter.ErrUnsupportedFormat, key)
+	}
+
+	config := converter.ImageConfig{
+		FileName:        path.Base(key),
+		File:            file,
+		Width:           transform.Width,
+		Height:          transform.Height,
+		FormatToConvert: transform.Format,
+		Quality:         transform.Quality,
+		Limits:          h.config.Limits,
+		Fit:             transform.Fit,
+	}
+	var output bytes.Buffer
+	if _, err := config.ConvertToContext(ctx, &output); err != nil {
+		return nil, false, err
+	}
+
+	if h.config.Cache != nil {
+		if _, err := h.config.Cache.Put(ctx, cacheKey, bytes.NewReader(output.Bytes()), converter.MIMEType(transform.Format)); err != nil {
+			// The output is served anyway, the next request transforms it again
+			log.Printf("server: failed to cache transform of %s: %v", key, err)
+		}
+	}
+	return output.Bytes(), false, nil
+}
+
+// cached reads the output of a cache key, a missing or unreadable entry is a miss
+func (h *TransformHandler) cached(ctx context.Context, cacheKey string) ([]byte, bool) {
+	if h.config.Cache == nil {
+		return nil, false
+	}
+	file, err := h.config.Cache.Get(ctx, cacheKey)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+	output, err := io.ReadAll(file)
+	if err != nil {
+		return nil, false
+	}
+	return output, true
+}
+
+// lock locks the cache key and returns the function that unlocks it.
+// It returns ErrCanceled when ctx is done before the lock is acquired.
+func (h *TransformHandler) lock(ctx context.Context, cacheKey string) (func(), error) {
+	h.mu.Lock()
+	lock, ok := h.running[cacheKey]
+	if !ok {
+		lock = &keyLock{ch: make(chan struct{}, 1)}
+		h.running[cacheKey] = lock
+	}
+	lock.users++
+	h.mu.Unlock()
+
+	release := func() {
+		h.mu.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(h.running, cacheKey)
+		}
+		h.mu.Unlock()
+	}
+
+	select {
+	case lock.ch <- struct{}{}:
+	case <-ctx.Done():
+		release()
+		return nil, fmt.Errorf("%w: waiting for the transform of the same image: %w", converter.ErrCanceled, ctx.Err())
+	}
+	return func() {
+		<-lock.ch
+		release()
+	}, nil
+}